@@ -0,0 +1,95 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const ageHeader = "age-encryption.org/v1\n"
+
+// AgeKeyFiles lists, in order of preference, the files searched for the age
+// identities used to decrypt user-data. The first one found is used.
+var AgeKeyFiles = []string{
+	"/usr/share/oem/coreos-cloudinit/age.key",
+	"/usr/share/coreos-cloudinit/age.key",
+}
+
+// ErrNoAgeKey is returned when encrypted content is found but none of the
+// AgeKeyFiles exist.
+var ErrNoAgeKey = errors.New("no age key found")
+
+// IsAgeEncrypted reports whether data is an age encrypted file, either
+// binary or ASCII armored.
+func IsAgeEncrypted(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if bytes.HasPrefix(data, []byte(armor.Header)) {
+		r := bufio.NewReader(armor.NewReader(bytes.NewReader(data)))
+		header, err := r.Peek(len(ageHeader))
+		return err == nil && string(header) == ageHeader
+	}
+	return bytes.HasPrefix(data, []byte(ageHeader))
+}
+
+// DecryptAgeContent decrypts an age encrypted file, either binary or ASCII
+// armored, with the identities read from the first of AgeKeyFiles found.
+func DecryptAgeContent(content []byte) ([]byte, error) {
+	identities, err := loadAgeIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	var src io.Reader = bytes.NewReader(content)
+	if trimmed := bytes.TrimLeft(content, " \t\r\n"); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(trimmed))
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt age content: %w", err)
+	}
+	output, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt age content: %w", err)
+	}
+	return output, nil
+}
+
+func loadAgeIdentities() ([]age.Identity, error) {
+	for _, name := range AgeKeyFiles {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age key file %q: %w", name, err)
+		}
+		return identities, nil
+	}
+	return nil, fmt.Errorf("%w (looked in %s)", ErrNoAgeKey, strings.Join(AgeKeyFiles, ", "))
+}
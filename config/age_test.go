@@ -0,0 +1,114 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageEncrypt encrypts plaintext to a new identity written to a key file in
+// a temporary directory, which AgeKeyFiles points to for the test.
+func ageEncrypt(t *testing.T, plaintext string, armored bool) []byte {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := path.Join(t.TempDir(), "age.key")
+	if err := os.WriteFile(key, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	files := AgeKeyFiles
+	AgeKeyFiles = []string{path.Join(t.TempDir(), "missing.key"), key}
+	t.Cleanup(func() { AgeKeyFiles = files })
+
+	buf := &bytes.Buffer{}
+	var dst io.WriteCloser = nopCloser{buf}
+	if armored {
+		dst = armor.NewWriter(buf)
+	}
+	w, err := age.Encrypt(dst, identity.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, plaintext)
+	w.Close()
+	dst.Close()
+	return buf.Bytes()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestDecodeAgeContent(t *testing.T) {
+	for _, tt := range []struct {
+		encoding string
+		armored  bool
+	}{
+		{"age", true},
+		{"age", false},
+		{"age+base64", false},
+		{"age+b64", true},
+	} {
+		content := ageEncrypt(t, "secret\n", tt.armored)
+		if !IsAgeEncrypted(content) {
+			t.Errorf("%s (armored %t): content not detected as encrypted", tt.encoding, tt.armored)
+		}
+		if tt.encoding != "age" {
+			content = []byte(base64.StdEncoding.EncodeToString(content))
+		}
+
+		output, err := DecodeContent(string(content), tt.encoding)
+		if err != nil {
+			t.Errorf("%s (armored %t): unexpected error: %v", tt.encoding, tt.armored, err)
+		} else if string(output) != "secret\n" {
+			t.Errorf("%s (armored %t): bad output: %q", tt.encoding, tt.armored, output)
+		}
+	}
+}
+
+func TestDecodeAgeContentWithoutKey(t *testing.T) {
+	content := ageEncrypt(t, "secret\n", true)
+	AgeKeyFiles = []string{path.Join(t.TempDir(), "missing.key")}
+
+	if _, err := DecodeContent(string(content), "age"); !errors.Is(err, ErrNoAgeKey) {
+		t.Errorf("bad error: want %v, got %v", ErrNoAgeKey, err)
+	}
+}
+
+func TestIsAgeEncrypted(t *testing.T) {
+	for _, tt := range []struct {
+		data      string
+		encrypted bool
+	}{
+		{"", false},
+		{"hello", false},
+		{"age-encryption.org/v1\n-> X25519", true},
+		{armor.Header + "\nnot base64\n" + armor.Footer + "\n", false},
+	} {
+		if encrypted := IsAgeEncrypted([]byte(tt.data)); encrypted != tt.encrypted {
+			t.Errorf("bad result (%q): want %t, got %t", tt.data, tt.encrypted, encrypted)
+		}
+	}
+}
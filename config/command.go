@@ -0,0 +1,43 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// Command is a command of the bootcmd or runcmd sections, given either as a
+// string, which is run by the shell, or as a list of arguments. Commands of
+// any other form are left empty, so that they fail when run and the
+// validator reports them instead of the whole cloud-config being rejected.
+type Command []string
+
+func (c *Command) UnmarshalYAML(value *yaml.Node) error {
+	*c = nil
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*c = Command{"/bin/sh", "-c", value.Value}
+	case yaml.SequenceNode:
+		var args Command
+		for _, arg := range value.Content {
+			if arg.Kind != yaml.ScalarNode {
+				return nil
+			}
+			args = append(args, arg.Value)
+		}
+		*c = args
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	cfg, err := NewCloudConfig(`#cloud-config
+bootcmd:
+  - echo "$HOSTNAME" > /tmp/host
+  - [ls, -l, 42]
+runcmd:
+  - [ls, [-l]]
+  - cmd: ls
+`)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+
+	bootcmd := []Command{
+		{"/bin/sh", "-c", `echo "$HOSTNAME" > /tmp/host`},
+		{"ls", "-l", "42"},
+	}
+	if !reflect.DeepEqual(bootcmd, cfg.Bootcmd) {
+		t.Errorf("bad bootcmd: want %q, got %q", bootcmd, cfg.Bootcmd)
+	}
+	// Invalid commands are left empty.
+	if len(cfg.Runcmd) != 2 || cfg.Runcmd[0] != nil || cfg.Runcmd[1] != nil {
+		t.Errorf("bad runcmd: want two empty commands, got %q", cfg.Runcmd)
+	}
+}
@@ -30,12 +30,29 @@ import (
 // directly to YAML. Fields that cannot be set in the cloud-config (fields
 // used for internal use) have the YAML tag '-' so that they aren't marshalled.
 type CloudConfig struct {
-	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
-	CoreOS            CoreOS   `yaml:"coreos"`
-	WriteFiles        []File   `yaml:"write_files"`
-	Hostname          string   `yaml:"hostname"`
-	Users             []User   `yaml:"users"`
-	ManageEtcHosts    EtcHosts `yaml:"manage_etc_hosts"`
+	SSHAuthorizedKeys []string      `yaml:"ssh_authorized_keys"`
+	CoreOS            CoreOS        `yaml:"coreos"`
+	WriteFiles        []File        `yaml:"write_files"`
+	Directories       []Directory   `yaml:"directories"`
+	Links             []Link        `yaml:"links"`
+	Hostname          string        `yaml:"hostname"`
+	FQDN              string        `yaml:"fqdn"`
+	PreferFQDN        bool          `yaml:"prefer_fqdn"`
+	Timezone          string        `yaml:"timezone" valid:"^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$"`
+	Locale            string        `yaml:"locale"   valid:"^[A-Za-z0-9_.@-]+$"`
+	Keymap            string        `yaml:"keymap"   valid:"^[A-Za-z0-9_.-]+$"`
+	NTP               NTP           `yaml:"ntp"`
+	Resolved          Resolved      `yaml:"resolved"`
+	CACerts           CACerts       `yaml:"ca_certs"`
+	Sysctl            Sysctl        `yaml:"sysctl"`
+	KernelModules     KernelModules `yaml:"kernel_modules"`
+	Users             []User        `yaml:"users"`
+	ManageEtcHosts    EtcHosts      `yaml:"manage_etc_hosts"   valid:"^(true|false|template|localhost)$"`
+	EtcHostsTemplate  string        `yaml:"etc_hosts_template"`
+	MergeHow          string        `yaml:"merge_how"`
+	Scripts           ScriptOptions `yaml:"scripts"`
+	Bootcmd           []Command     `yaml:"bootcmd"`
+	Runcmd            []Command     `yaml:"runcmd"`
 }
 
 type CoreOS struct {
@@ -58,6 +75,15 @@ func IsCloudConfig(userdata string) bool {
 	return (header == "#cloud-config")
 }
 
+func IsCloudConfigArchive(userdata string) bool {
+	header := strings.SplitN(userdata, "\n", 2)[0]
+
+	// Trim trailing whitespaces
+	header = strings.TrimRightFunc(header, unicode.IsSpace)
+
+	return (header == "#cloud-config-archive")
+}
+
 func IsMultipartMime(userdata string) bool {
 	bufioReader := bufio.NewReader(strings.NewReader(userdata))
 	textProtoReader := textproto.NewReader(bufioReader)
@@ -171,6 +197,8 @@ func isZero(v reflect.Value) bool {
 			}
 		}
 		return true
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
 	default:
 		return v.Interface() == reflect.Zero(v.Type()).Interface()
 	}
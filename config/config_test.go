@@ -127,6 +127,8 @@ func TestIsZero(t *testing.T) {
 		{struct{ A string }{A: "hello"}, false},
 		{struct{ A int }{}, true},
 		{struct{ A int }{A: 1}, false},
+		{struct{ A []string }{}, true},
+		{struct{ A []string }{A: []string{"a"}}, false},
 	}
 
 	for _, tt := range tests {
@@ -182,8 +184,11 @@ func TestConfigCompile(t *testing.T) {
 		File{},
 		Flannel{},
 		Fleet{},
+		KernelModule{},
 		Locksmith{},
+		NTP{},
 		OEM{},
+		Resolved{},
 		Unit{},
 		Update{},
 	}
@@ -0,0 +1,467 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package convert translates cloud-configs into the equivalent Ignition
+// configs and Butane configs, for the users moving to Ignition.
+package convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/config/validate"
+	"github.com/flatcar/coreos-cloudinit/pkg"
+	"github.com/flatcar/coreos-cloudinit/system"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatIgnition = "ignition"
+	FormatButane   = "butane"
+
+	ignitionVersion = "3.3.0"
+	butaneVariant   = "flatcar"
+	butaneVersion   = "1.0.0"
+)
+
+// Convert translates the cloud-config into an Ignition config or a Butane
+// config, according to the format. Everything which can't be translated is
+// left out of the result and reported, along with its line.
+func Convert(userdata []byte, format string) ([]byte, validate.Report, error) {
+	report := validate.Report{}
+	if format != FormatIgnition && format != FormatButane {
+		return nil, report, fmt.Errorf("unsupported format %q (supported formats: %q, %q)", format, FormatIgnition, FormatButane)
+	}
+	if !config.IsCloudConfig(string(userdata)) {
+		return nil, report, fmt.Errorf("only cloud-configs can be converted")
+	}
+
+	cfg, err := config.NewCloudConfig(string(userdata))
+	if err != nil {
+		return nil, report, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(userdata, &doc); err != nil {
+		return nil, report, err
+	}
+
+	c := converter{doc: &doc, report: &report, butane: format == FormatButane}
+	out := c.convert(*cfg)
+
+	var data []byte
+	if c.butane {
+		out.Variant = butaneVariant
+		out.Version = butaneVersion
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err = enc.Encode(out)
+		data = buf.Bytes()
+	} else {
+		out.Ignition = &ignitionSection{Version: ignitionVersion}
+		data, err = json.MarshalIndent(out, "", "  ")
+		data = append(data, '\n')
+	}
+	return data, report, err
+}
+
+type converter struct {
+	doc    *yaml.Node
+	report *validate.Report
+	butane bool
+}
+
+func (c *converter) convert(cfg config.CloudConfig) *ignitionConfig {
+	out := &ignitionConfig{}
+
+	for i, d := range cfg.Directories {
+		perm, err := (&system.Directory{Directory: d}).Permissions()
+		if err != nil {
+			c.untranslatable(err.Error(), "directories", i, "permissions")
+			continue
+		}
+		m := mode(perm)
+		dir := directory{Path: path.Join("/", d.Path), Mode: &m, Overwrite: d.Overwrite}
+		dir.User, dir.Group = newOwners(d.Owner)
+		out.Storage.Directories = append(out.Storage.Directories, dir)
+	}
+	for i, f := range cfg.WriteFiles {
+		if file, ok := c.writeFile(i, f); ok {
+			out.Storage.Files = append(out.Storage.Files, file)
+		}
+	}
+	for _, l := range cfg.Links {
+		lnk := link{Path: path.Join("/", l.Path), Target: l.Target, Hard: l.Hard, Overwrite: l.Overwrite}
+		lnk.User, lnk.Group = newOwners(l.Owner)
+		out.Storage.Links = append(out.Storage.Links, lnk)
+	}
+	if hostname := staticHostname(cfg); hostname != "" {
+		out.Storage.Files = append(out.Storage.Files, c.file(config.File{
+			Path:    "/etc/hostname",
+			Content: hostname + "\n",
+		}, 0644))
+	}
+	if cfg.Timezone != "" {
+		out.Storage.Links = append(out.Storage.Links, link{
+			Path:      "/etc/localtime",
+			Target:    path.Join("../usr/share/zoneinfo", cfg.Timezone),
+			Overwrite: true,
+		})
+	}
+	if cfg.Locale != "" {
+		out.Storage.Files = append(out.Storage.Files, c.file(config.File{
+			Path:    "/etc/locale.conf",
+			Content: "LANG=" + cfg.Locale + "\n",
+		}, 0644))
+	}
+	if cfg.Keymap != "" {
+		out.Storage.Files = append(out.Storage.Files, c.file(config.File{
+			Path:    "/etc/vconsole.conf",
+			Content: "KEYMAP=" + cfg.Keymap + "\n",
+		}, 0644))
+	}
+	update := system.Update{Update: cfg.CoreOS.Update, ReadConfig: emptyConfig}
+	if f, err := update.File(); err != nil {
+		c.untranslatable(err.Error(), "coreos", "update")
+	} else if f != nil {
+		out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+	}
+	ntp := system.NTP{NTP: cfg.NTP}
+	if f, err := ntp.File(); err != nil {
+		c.untranslatable(err.Error(), "ntp")
+	} else if f != nil {
+		out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+	}
+	for _, f := range (system.CACerts{CACerts: cfg.CACerts}).Files() {
+		out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+	}
+	if cfg.CACerts.RemoveDefaults {
+		c.untranslatable(`"ca_certs.remove_defaults" cannot be translated`, "ca_certs", "remove_defaults")
+	}
+	if f, err := (system.Resolved{Resolved: cfg.Resolved}).File(); err != nil {
+		c.untranslatable(err.Error(), "resolved")
+	} else if f != nil {
+		out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+	}
+	if f, err := (system.Sysctl{Sysctl: cfg.Sysctl}).File(); err != nil {
+		c.untranslatable(err.Error(), "sysctl")
+	} else if f != nil {
+		out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+	}
+	if fs, err := (system.KernelModules{KernelModules: cfg.KernelModules}).Files(); err != nil {
+		c.untranslatable(err.Error(), "kernel_modules")
+	} else {
+		for _, f := range fs {
+			out.Storage.Files = append(out.Storage.Files, c.file(f.File, 0644))
+		}
+	}
+
+	for i, u := range cfg.CoreOS.Units {
+		out.Systemd.addUnit(c.unit(i, u))
+	}
+	if !config.IsZero(cfg.CoreOS.Locksmith) {
+		for _, u := range (system.Locksmith{Locksmith: cfg.CoreOS.Locksmith}).Units() {
+			out.Systemd.addUnit(unit{Name: u.Name, Dropins: dropins(u.DropIns)})
+		}
+	}
+	if cfg.CoreOS.Update.RebootStrategy == "off" {
+		out.Systemd.addUnit(unit{Name: "locksmithd.service", Mask: true})
+	}
+	// Restarting the client is translated to enabling it, as timesyncd
+	// already is.
+	for _, u := range ntp.Units() {
+		if u.Mask {
+			out.Systemd.addUnit(unit{Name: u.Name, Mask: true})
+		} else if u.Name != "systemd-timesyncd.service" {
+			enabled := true
+			out.Systemd.addUnit(unit{Name: u.Name, Enabled: &enabled})
+		}
+	}
+
+	for i, u := range cfg.Users {
+		if u.Name == "" {
+			c.untranslatable("user without a name cannot be translated", "users", i)
+			continue
+		}
+		out.Passwd.addUser(c.user(i, u))
+	}
+	if len(cfg.SSHAuthorizedKeys) > 0 {
+		out.Passwd.addUser(user{Name: "core", SSHAuthorizedKeys: cfg.SSHAuthorizedKeys})
+	}
+
+	for _, key := range [][]interface{}{
+		{"coreos", "etcd"},
+		{"coreos", "etcd2"},
+		{"coreos", "fleet"},
+		{"coreos", "flannel"},
+		{"coreos", "oem"},
+		{"manage_etc_hosts"},
+		{"scripts"},
+		{"bootcmd"},
+		{"runcmd"},
+	} {
+		if line := c.line(key...); line != 0 {
+			c.report.Warning(line, fmt.Sprintf("%q cannot be translated", keyName(key)))
+		}
+	}
+
+	return out
+}
+
+// staticHostname returns the hostname coreos-cloudinit would set: the fqdn
+// if it's preferred, or else the first label of the hostname or fqdn.
+func staticHostname(cfg config.CloudConfig) string {
+	fqdn := strings.TrimSuffix(cfg.FQDN, ".")
+	if cfg.PreferFQDN && fqdn != "" && len(fqdn) <= 64 {
+		return fqdn
+	}
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname = fqdn
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return hostname
+}
+
+func (c *converter) writeFile(i int, f config.File) (file, bool) {
+	perm, err := (&system.File{File: f}).Permissions()
+	if err != nil {
+		c.untranslatable(err.Error(), "write_files", i, "permissions")
+		return file{}, false
+	}
+
+	switch f.Encoding {
+	case "age", "age+base64", "age+b64":
+		c.untranslatable(fmt.Sprintf("age encrypted content of %q cannot be translated", f.Path), "write_files", i, "encoding")
+		return file{}, false
+	case "gz", "gzip":
+		f.Encoding = "gzip"
+	case "gz+base64", "gzip+base64", "gz+b64", "gzip+b64":
+		content, err := config.DecodeBase64Content(f.Content)
+		if err != nil {
+			c.untranslatable(fmt.Sprintf("content of %q cannot be decoded: %v", f.Path, err), "write_files", i, "content")
+			return file{}, false
+		}
+		f.Content, f.Encoding = string(content), "gzip"
+	default:
+		content, err := config.DecodeContent(f.Content, f.Encoding)
+		if err != nil {
+			c.untranslatable(fmt.Sprintf("content of %q cannot be decoded: %v", f.Path, err), "write_files", i, "content")
+			return file{}, false
+		}
+		f.Content, f.Encoding = string(content), ""
+	}
+
+	out := c.file(f, int(perm))
+	if f.Source.URI != "" {
+		source, ok := c.source(i, f.Source)
+		if !ok {
+			return file{}, false
+		}
+		if f.Append {
+			out.Append = []resource{source}
+		} else {
+			out.Contents = &source
+		}
+	}
+	out.User, out.Group = newOwners(f.Owner)
+	return out, true
+}
+
+// file translates the file, whose content is either plain or gzip
+// compressed.
+func (c *converter) file(f config.File, perm int) file {
+	out := file{
+		Path: path.Join("/", f.Path),
+		Mode: (*mode)(&perm),
+	}
+	contents := resource{}
+	if f.Encoding == "gzip" {
+		contents.Compression = "gzip"
+	}
+	if c.butane && f.Encoding == "" && utf8.ValidString(f.Content) {
+		contents.Inline = f.Content
+	} else {
+		contents.Source = "data:;base64," + base64.StdEncoding.EncodeToString([]byte(f.Content))
+	}
+	if f.Append {
+		out.Append = []resource{contents}
+	} else {
+		out.Contents, out.Overwrite = &contents, true
+	}
+	return out
+}
+
+// source translates the source of the file into the resource it's
+// downloaded from.
+func (c *converter) source(i int, s config.FileSource) (resource, bool) {
+	if strings.HasPrefix(strings.ToLower(s.URI), "file:") {
+		c.untranslatable(fmt.Sprintf("source %q cannot be translated", s.URI), "write_files", i, "source", "uri")
+		return resource{}, false
+	}
+	out := resource{Source: s.URI}
+	for name, values := range s.Headers.Header() {
+		for _, value := range values {
+			out.HTTPHeaders = append(out.HTTPHeaders, httpHeader{Name: name, Value: value})
+		}
+	}
+	sort.Slice(out.HTTPHeaders, func(i, j int) bool { return out.HTTPHeaders[i].Name < out.HTTPHeaders[j].Name })
+	if s.Checksum != "" {
+		checksum, err := pkg.ParseChecksum(s.Checksum)
+		if err != nil {
+			c.untranslatable(err.Error(), "write_files", i, "source", "checksum")
+			return resource{}, false
+		}
+		out.Verification = &verification{Hash: fmt.Sprintf("%s-%x", checksum.Algorithm, checksum.Sum)}
+	}
+	return out, true
+}
+
+func (c *converter) unit(i int, u config.Unit) unit {
+	out := unit{
+		Name:     u.Name,
+		Mask:     u.Mask,
+		Contents: u.Content,
+		Dropins:  dropins(u.DropIns),
+	}
+	if u.Enable {
+		enabled := true
+		out.Enabled = &enabled
+	}
+	if u.Runtime {
+		c.untranslatable(fmt.Sprintf("%q is written to /etc instead of /run", u.Name), "coreos", "units", i, "runtime")
+	}
+	if u.Command != "" {
+		c.untranslatable(fmt.Sprintf("command %q of %q cannot be translated, the unit can be enabled instead", u.Command, u.Name), "coreos", "units", i, "command")
+	}
+	return out
+}
+
+func dropins(ds []config.UnitDropIn) []dropin {
+	var out []dropin
+	for _, d := range ds {
+		out = append(out, dropin{Name: d.Name, Contents: d.Content})
+	}
+	return out
+}
+
+func (c *converter) user(i int, u config.User) user {
+	out := user{
+		Name:              u.Name,
+		SSHAuthorizedKeys: u.SSHAuthorizedKeys,
+		Gecos:             u.GECOS,
+		HomeDir:           u.Homedir,
+		NoCreateHome:      u.NoCreateHome,
+		PrimaryGroup:      u.PrimaryGroup,
+		Groups:            u.Groups,
+		NoUserGroup:       u.NoUserGroup,
+		NoLogInit:         u.NoLogInit,
+		System:            u.System,
+		Shell:             u.Shell,
+	}
+	if u.PasswordHash != "" {
+		out.PasswordHash = &u.PasswordHash
+	}
+	for _, key := range []string{"coreos_ssh_import_github", "coreos_ssh_import_github_users", "coreos_ssh_import_url"} {
+		if line := c.line("users", i, key); line != 0 {
+			c.report.Warning(line, fmt.Sprintf("%q cannot be translated", key))
+		}
+	}
+	return out
+}
+
+// untranslatable reports the message at the line of the given path of
+// keys and indices, or of its closest parent.
+func (c *converter) untranslatable(message string, path ...interface{}) {
+	line := 0
+	for i := len(path); line == 0 && i > 0; i-- {
+		line = c.line(path[:i]...)
+	}
+	c.report.Warning(line, message)
+}
+
+// line returns the line of the key or element at the given path of keys and
+// indices, or 0 if it isn't in the document. Hyphens and underscores are
+// equivalent in keys.
+func (c *converter) line(path ...interface{}) int {
+	if len(c.doc.Content) == 0 {
+		return 0
+	}
+	n, line := c.doc.Content[0], 0
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			if n.Kind != yaml.MappingNode {
+				return 0
+			}
+			var found *yaml.Node
+			for j := 0; j+1 < len(n.Content); j += 2 {
+				if strings.Replace(n.Content[j].Value, "-", "_", -1) == p {
+					line, found = n.Content[j].Line, n.Content[j+1]
+				}
+			}
+			if found == nil {
+				return 0
+			}
+			n = found
+		case int:
+			if n.Kind != yaml.SequenceNode || p >= len(n.Content) {
+				return 0
+			}
+			n = n.Content[p]
+			line = n.Line
+		}
+	}
+	return line
+}
+
+func keyName(path []interface{}) string {
+	var parts []string
+	for _, p := range path {
+		parts = append(parts, fmt.Sprint(p))
+	}
+	return strings.Join(parts, ".")
+}
+
+func emptyConfig() (io.Reader, error) {
+	return strings.NewReader(""), nil
+}
+
+// newOwners returns the user and group of the owner, of the form
+// "user:group".
+func newOwners(s string) (*owner, *owner) {
+	user, group, _ := strings.Cut(s, ":")
+	return newOwner(user), newOwner(group)
+}
+
+// newOwner returns the owner given by name or ID, or nil if it's empty.
+func newOwner(s string) *owner {
+	if s == "" {
+		return nil
+	}
+	if id, err := strconv.Atoi(s); err == nil {
+		return &owner{ID: &id}
+	}
+	return &owner{Name: s}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConvertIgnition(t *testing.T) {
+	tests := []struct {
+		config string
+
+		ignition string
+		entries  []string
+	}{
+		{
+			config:   "#cloud-config",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nhostname: host",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/hostname","mode":420,"overwrite":true,"contents":{"source":"data:;base64,aG9zdAo="}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nhostname: host\nfqdn: host.example.com\nprefer_fqdn: true",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/hostname","mode":420,"overwrite":true,"contents":{"source":"data:;base64,aG9zdC5leGFtcGxlLmNvbQo="}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nwrite_files:\n  - path: /etc/motd\n    owner: core:500\n    permissions: '0600'\n    encoding: b64\n    content: aGkK",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/motd","mode":384,"user":{"name":"core"},"group":{"id":500},"overwrite":true,"contents":{"source":"data:;base64,aGkK"}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nwrite_files:\n  - path: /etc/motd\n    encoding: gzip+base64\n    content: H4sIAOC3tVQAA8tIzcnJ5wIAIDA6NgYAAAA=",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/motd","mode":420,"overwrite":true,"contents":{"source":"data:;base64,H4sIAOC3tVQAA8tIzcnJ5wIAIDA6NgYAAAA=","compression":"gzip"}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nwrite_files:\n  - path: /etc/motd\n    append: true\n    content: hi\n  - path: /etc/remote\n    source:\n      uri: https://example.com/remote\n      headers:\n        Authorization: Bearer token\n      checksum: sha256=e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n  - path: /etc/local\n    source:\n      uri: file:///etc/local",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/motd","mode":420,"append":[{"source":"data:;base64,aGk="}]},{"path":"/etc/remote","mode":420,"overwrite":true,"contents":{"source":"https://example.com/remote","httpHeaders":[{"name":"Authorization","value":"Bearer token"}],"verification":{"hash":"sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}}]},"systemd":{},"passwd":{}}`,
+			entries:  []string{`line 14: warning: source "file:///etc/local" cannot be translated`},
+		},
+		{
+			config:   "#cloud-config\ndirectories:\n  - path: /var/lib/app\n    permissions: '0700'\n    owner: app:app\nlinks:\n  - path: /etc/app.conf\n    target: /var/lib/app/app.conf\n    overwrite: true",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"directories":[{"path":"/var/lib/app","mode":448,"user":{"name":"app"},"group":{"name":"app"}}],"links":[{"path":"/etc/app.conf","target":"/var/lib/app/app.conf","overwrite":true}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\ntimezone: Europe/Berlin\nlocale: de_DE.UTF-8\nkeymap: de",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/locale.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,TEFORz1kZV9ERS5VVEYtOAo="}},{"path":"/etc/vconsole.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,S0VZTUFQPWRlCg=="}}],"links":[{"path":"/etc/localtime","target":"../usr/share/zoneinfo/Europe/Berlin","overwrite":true}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nntp:\n  ntp_client: chrony\n  servers: [ntp1]",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/chrony/chrony.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,c2VydmVyIG50cDEgaWJ1cnN0CmRyaWZ0ZmlsZSAvdmFyL2xpYi9jaHJvbnkvZHJpZnQKbWFrZXN0ZXAgMS4wIDMKcnRjc3luYwo="}}]},"systemd":{"units":[{"name":"systemd-timesyncd.service","mask":true},{"name":"chronyd.service","enabled":true}]},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nca_certs:\n  remove_defaults: true\n  trusted:\n    - cert",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/ssl/certs/cloudinit-0.pem","mode":420,"overwrite":true,"contents":{"source":"data:;base64,Y2VydAo="}}]},"systemd":{},"passwd":{}}`,
+			entries:  []string{`line 3: warning: "ca_certs.remove_defaults" cannot be translated`},
+		},
+		{
+			config:   "#cloud-config\nresolved:\n  dns: [192.0.2.1]",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/systemd/resolved.conf.d/50-cloudinit.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,W1Jlc29sdmVdCkROUz0xOTIuMC4yLjEK"}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nsysctl:\n  vm.swappiness: 10\nkernel_modules:\n  - name: bonding\n    options: mode=1",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/sysctl.d/90-cloudinit.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,dm0uc3dhcHBpbmVzcyA9IDEwCg=="}},{"path":"/etc/modules-load.d/90-cloudinit.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,Ym9uZGluZwo="}},{"path":"/etc/modprobe.d/90-cloudinit.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,b3B0aW9ucyBib25kaW5nIG1vZGU9MQo="}}]},"systemd":{},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nwrite_files:\n  - path: /etc/secret\n    encoding: age\n    content: secret",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{},"systemd":{},"passwd":{}}`,
+			entries:  []string{`line 4: warning: age encrypted content of "/etc/secret" cannot be translated`},
+		},
+		{
+			config:   "#cloud-config\ncoreos:\n  units:\n    - name: web.service\n      enable: true\n      command: start\n      content: '[Service]'\n      drop_ins:\n        - name: 10-env.conf\n          content: '[Service]'",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{},"systemd":{"units":[{"name":"web.service","enabled":true,"contents":"[Service]","dropins":[{"name":"10-env.conf","contents":"[Service]"}]}]},"passwd":{}}`,
+			entries:  []string{`line 6: warning: command "start" of "web.service" cannot be translated, the unit can be enabled instead`},
+		},
+		{
+			config:   "#cloud-config\ncoreos:\n  update:\n    reboot_strategy: off\n  locksmith:\n    window_start: 10:00",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{"files":[{"path":"/etc/coreos/update.conf","mode":420,"overwrite":true,"contents":{"source":"data:;base64,UkVCT09UX1NUUkFURUdZPW9mZgo="}}]},"systemd":{"units":[{"name":"locksmithd.service","mask":true,"dropins":[{"name":"20-cloudinit.conf","contents":"[Service]\nEnvironment=\"REBOOT_WINDOW_START=10:00\"\n"}]}]},"passwd":{}}`,
+		},
+		{
+			config:   "#cloud-config\nssh_authorized_keys:\n  - key1\nusers:\n  - name: core\n    passwd: hash\n    ssh_authorized_keys:\n      - key2\n  - name: admin\n    groups: [sudo]\n    coreos_ssh_import_github: admin",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{},"systemd":{},"passwd":{"users":[{"name":"core","passwordHash":"hash","sshAuthorizedKeys":["key2","key1"]},{"name":"admin","groups":["sudo"]}]}}`,
+			entries:  []string{`line 11: warning: "coreos_ssh_import_github" cannot be translated`},
+		},
+		{
+			config:   "#cloud-config\nmanage_etc_hosts: localhost\nruncmd:\n  - ls\ncoreos:\n  oem:\n    id: test",
+			ignition: `{"ignition":{"version":"3.3.0"},"storage":{},"systemd":{},"passwd":{}}`,
+			entries: []string{
+				`line 6: warning: "coreos.oem" cannot be translated`,
+				`line 2: warning: "manage_etc_hosts" cannot be translated`,
+				`line 3: warning: "runcmd" cannot be translated`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		out, r, err := Convert([]byte(tt.config), FormatIgnition)
+		if err != nil {
+			t.Errorf("bad error (%d): want nil, got %v", i, err)
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Errorf("bad output (%d): %v", i, err)
+		}
+		if err := json.Unmarshal([]byte(tt.ignition), &want); err != nil {
+			panic(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("bad ignition config (%d, %q): want %s, got %s", i, tt.config, tt.ignition, out)
+		}
+
+		var entries []string
+		for _, e := range r.Entries() {
+			entries = append(entries, e.String())
+		}
+		if !reflect.DeepEqual(tt.entries, entries) {
+			t.Errorf("bad report (%d, %q): want %q, got %q", i, tt.config, tt.entries, entries)
+		}
+	}
+}
+
+func TestConvertButane(t *testing.T) {
+	tests := []struct {
+		config string
+
+		butane string
+	}{
+		{
+			config: "#cloud-config",
+			butane: "variant: flatcar\nversion: 1.0.0\n",
+		},
+		{
+			config: "#cloud-config\nhostname: host\nwrite_files:\n  - path: /etc/bin\n    permissions: '0755'\n    encoding: base64\n    content: /w==\nusers:\n  - name: admin\n    homedir: /home/admin",
+			butane: `variant: flatcar
+version: 1.0.0
+storage:
+  files:
+    - path: /etc/bin
+      mode: 0755
+      overwrite: true
+      contents:
+        source: data:;base64,/w==
+    - path: /etc/hostname
+      mode: 0644
+      overwrite: true
+      contents:
+        inline: |
+          host
+passwd:
+  users:
+    - name: admin
+      home_dir: /home/admin
+`,
+		},
+	}
+
+	for i, tt := range tests {
+		out, _, err := Convert([]byte(tt.config), FormatButane)
+		if err != nil {
+			t.Errorf("bad error (%d): want nil, got %v", i, err)
+			continue
+		}
+		if string(out) != tt.butane {
+			t.Errorf("bad butane config (%d, %q): want %q, got %q", i, tt.config, tt.butane, out)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		userdata string
+		format   string
+	}{
+		{"#cloud-config", "cloud-config"},
+		{"#!/bin/bash", FormatIgnition},
+		{"#cloud-config\nhostname: [", FormatButane},
+	}
+
+	for i, tt := range tests {
+		if _, _, err := Convert([]byte(tt.userdata), tt.format); err == nil {
+			t.Errorf("bad error (%d, %q): want non-nil, got nil", i, tt.userdata)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ignitionConfig is marshalled to JSON as an Ignition config of spec version
+// 3 and to YAML as a Butane config of the flatcar variant, which differ by
+// their header and the case of their keys.
+type ignitionConfig struct {
+	Variant  string           `json:"-"                  yaml:"variant"`
+	Version  string           `json:"-"                  yaml:"version"`
+	Ignition *ignitionSection `json:"ignition,omitempty" yaml:"-"`
+	Storage  storage          `json:"storage"            yaml:"storage,omitempty"`
+	Systemd  systemd          `json:"systemd"            yaml:"systemd,omitempty"`
+	Passwd   passwd           `json:"passwd"             yaml:"passwd,omitempty"`
+}
+
+type ignitionSection struct {
+	Version string `json:"version"`
+}
+
+type storage struct {
+	Directories []directory `json:"directories,omitempty" yaml:"directories,omitempty"`
+	Files       []file      `json:"files,omitempty"       yaml:"files,omitempty"`
+	Links       []link      `json:"links,omitempty"       yaml:"links,omitempty"`
+}
+
+type directory struct {
+	Path      string `json:"path"                yaml:"path"`
+	Mode      *mode  `json:"mode,omitempty"      yaml:"mode,omitempty"`
+	User      *owner `json:"user,omitempty"      yaml:"user,omitempty"`
+	Group     *owner `json:"group,omitempty"     yaml:"group,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty" yaml:"overwrite,omitempty"`
+}
+
+type link struct {
+	Path      string `json:"path"                yaml:"path"`
+	Target    string `json:"target"              yaml:"target"`
+	Hard      bool   `json:"hard,omitempty"      yaml:"hard,omitempty"`
+	User      *owner `json:"user,omitempty"      yaml:"user,omitempty"`
+	Group     *owner `json:"group,omitempty"     yaml:"group,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty" yaml:"overwrite,omitempty"`
+}
+
+type file struct {
+	Path      string     `json:"path"                yaml:"path"`
+	Mode      *mode      `json:"mode,omitempty"      yaml:"mode,omitempty"`
+	User      *owner     `json:"user,omitempty"      yaml:"user,omitempty"`
+	Group     *owner     `json:"group,omitempty"     yaml:"group,omitempty"`
+	Overwrite bool       `json:"overwrite,omitempty" yaml:"overwrite,omitempty"`
+	Contents  *resource  `json:"contents,omitempty"  yaml:"contents,omitempty"`
+	Append    []resource `json:"append,omitempty"    yaml:"append,omitempty"`
+}
+
+type resource struct {
+	Source       string        `json:"source,omitempty"       yaml:"source,omitempty"`
+	Inline       string        `json:"-"                      yaml:"inline,omitempty"`
+	Compression  string        `json:"compression,omitempty"  yaml:"compression,omitempty"`
+	HTTPHeaders  []httpHeader  `json:"httpHeaders,omitempty"  yaml:"http_headers,omitempty"`
+	Verification *verification `json:"verification,omitempty" yaml:"verification,omitempty"`
+}
+
+type httpHeader struct {
+	Name  string `json:"name"  yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type verification struct {
+	Hash string `json:"hash" yaml:"hash"`
+}
+
+// mode is marshalled to YAML in octal, as it's usually written.
+type mode int
+
+func (m mode) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprintf("0%o", int(m))}, nil
+}
+
+type owner struct {
+	ID   *int   `json:"id,omitempty"   yaml:"id,omitempty"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+type systemd struct {
+	Units []unit `json:"units,omitempty" yaml:"units,omitempty"`
+}
+
+type unit struct {
+	Name     string   `json:"name"               yaml:"name"`
+	Enabled  *bool    `json:"enabled,omitempty"  yaml:"enabled,omitempty"`
+	Mask     bool     `json:"mask,omitempty"     yaml:"mask,omitempty"`
+	Contents string   `json:"contents,omitempty" yaml:"contents,omitempty"`
+	Dropins  []dropin `json:"dropins,omitempty"  yaml:"dropins,omitempty"`
+}
+
+type dropin struct {
+	Name     string `json:"name"               yaml:"name"`
+	Contents string `json:"contents,omitempty" yaml:"contents,omitempty"`
+}
+
+// addUnit adds the unit or, if there is already a unit of the same name,
+// merges it into the existing one.
+func (s *systemd) addUnit(u unit) {
+	for i := range s.Units {
+		e := &s.Units[i]
+		if e.Name != u.Name {
+			continue
+		}
+		if u.Enabled != nil {
+			e.Enabled = u.Enabled
+		}
+		if u.Contents != "" {
+			e.Contents = u.Contents
+		}
+		e.Mask = e.Mask || u.Mask
+		e.Dropins = append(e.Dropins, u.Dropins...)
+		return
+	}
+	s.Units = append(s.Units, u)
+}
+
+type passwd struct {
+	Users []user `json:"users,omitempty" yaml:"users,omitempty"`
+}
+
+type user struct {
+	Name              string   `json:"name"                        yaml:"name"`
+	PasswordHash      *string  `json:"passwordHash,omitempty"      yaml:"password_hash,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty" yaml:"ssh_authorized_keys,omitempty"`
+	Gecos             string   `json:"gecos,omitempty"             yaml:"gecos,omitempty"`
+	HomeDir           string   `json:"homeDir,omitempty"           yaml:"home_dir,omitempty"`
+	NoCreateHome      bool     `json:"noCreateHome,omitempty"      yaml:"no_create_home,omitempty"`
+	PrimaryGroup      string   `json:"primaryGroup,omitempty"      yaml:"primary_group,omitempty"`
+	Groups            []string `json:"groups,omitempty"            yaml:"groups,omitempty"`
+	NoUserGroup       bool     `json:"noUserGroup,omitempty"       yaml:"no_user_group,omitempty"`
+	NoLogInit         bool     `json:"noLogInit,omitempty"         yaml:"no_log_init,omitempty"`
+	System            bool     `json:"system,omitempty"            yaml:"system,omitempty"`
+	Shell             string   `json:"shell,omitempty"             yaml:"shell,omitempty"`
+}
+
+// addUser adds the user or, if there is already a user of the same name,
+// adds its SSH keys to the existing one.
+func (p *passwd) addUser(u user) {
+	for i := range p.Users {
+		if p.Users[i].Name == u.Name {
+			p.Users[i].SSHAuthorizedKeys = append(p.Users[i].SSHAuthorizedKeys, u.SSHAuthorizedKeys...)
+			return
+		}
+	}
+	p.Users = append(p.Users, u)
+}
@@ -50,6 +50,18 @@ func DecodeContent(content string, encoding string) ([]byte, error) {
 		}
 
 		return DecodeGzipContent(string(gz))
+
+	case "age":
+		return DecryptAgeContent([]byte(content))
+
+	case "age+base64", "age+b64":
+		encrypted, err := DecodeBase64Content(content)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return DecryptAgeContent(encrypted)
 	}
 
 	return nil, fmt.Errorf("Unsupported encoding %q", encoding)
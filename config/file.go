@@ -14,10 +14,66 @@
 
 package config
 
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
 type File struct {
-	Encoding           string `yaml:"encoding" valid:"^(base64|b64|gz|gzip|gz\\+base64|gzip\\+base64|gz\\+b64|gzip\\+b64)$"`
-	Content            string `yaml:"content"`
-	Owner              string `yaml:"owner"`
-	Path               string `yaml:"path"`
-	RawFilePermissions string `yaml:"permissions" valid:"^0?[0-7]{3,4}$"`
+	Encoding           string     `yaml:"encoding" valid:"^(base64|b64|gz|gzip|gz\\+base64|gzip\\+base64|gz\\+b64|gzip\\+b64|age|age\\+base64|age\\+b64)$"`
+	Content            string     `yaml:"content"`
+	Owner              string     `yaml:"owner" valid:"^([A-Za-z0-9_][A-Za-z0-9_.-]*\\$?)?(:([A-Za-z0-9_][A-Za-z0-9_.-]*\\$?)?)?$"`
+	Path               string     `yaml:"path"`
+	RawFilePermissions string     `yaml:"permissions" valid:"^0?[0-7]{3,4}$"`
+	Append             bool       `yaml:"append"`
+	Defer              bool       `yaml:"defer"`
+	Source             FileSource `yaml:"source"`
+}
+
+// FileSource is the URL the content of a file is downloaded from, with any
+// supported scheme, along with the headers of the HTTP requests and the
+// expected digest of the content (e.g. "sha256=<hex>").
+type FileSource struct {
+	URI      string      `yaml:"uri"`
+	Headers  FileHeaders `yaml:"headers"`
+	Checksum string      `yaml:"checksum"`
+}
+
+// FileHeaders are HTTP headers of the form "Name: value", given either as a
+// list or, as in cloud-init, as a mapping of names to values. Headers of any
+// other form are left out and reported by the validator.
+type FileHeaders []string
+
+func (h *FileHeaders) UnmarshalYAML(value *yaml.Node) error {
+	*h = nil
+	switch value.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			*h = append(*h, value.Content[i].Value+": "+value.Content[i+1].Value)
+		}
+	case yaml.SequenceNode:
+		for _, header := range value.Content {
+			*h = append(*h, header.Value)
+		}
+	}
+	return nil
+}
+
+// Header returns the headers to be set on HTTP requests, skipping those
+// which aren't of the form "Name: value".
+func (h FileHeaders) Header() http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	header := http.Header{}
+	for _, line := range h {
+		name, value, ok := strings.Cut(line, ":")
+		if name = strings.TrimSpace(name); ok && name != "" {
+			header.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value))
+		}
+	}
+	return header
 }
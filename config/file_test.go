@@ -15,7 +15,11 @@
 package config
 
 import (
+	"net/http"
+	"reflect"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestEncodingValid(t *testing.T) {
@@ -67,3 +71,62 @@ func TestRawFilePermissionsValid(t *testing.T) {
 		}
 	}
 }
+
+func TestOwnerValid(t *testing.T) {
+	tests := []struct {
+		value string
+
+		isValid bool
+	}{
+		{value: "core", isValid: true},
+		{value: "core:core", isValid: true},
+		{value: "core:", isValid: true},
+		{value: ":docker", isValid: true},
+		{value: "500:500", isValid: true},
+		{value: "systemd-network", isValid: true},
+		{value: "core:core:core", isValid: false},
+		{value: "core docker", isValid: false},
+		{value: "-core", isValid: false},
+	}
+
+	for _, tt := range tests {
+		isValid := (nil == AssertStructValid(File{Owner: tt.value}))
+		if tt.isValid != isValid {
+			t.Errorf("bad assert (%s): want %t, got %t", tt.value, tt.isValid, isValid)
+		}
+	}
+}
+
+func TestFileHeaders(t *testing.T) {
+	tests := []struct {
+		config string
+
+		header http.Header
+	}{
+		{
+			config: "uri: https://example.com",
+		},
+		{
+			config: "headers:\n  authorization: Bearer token\n  X-Custom: a",
+			header: http.Header{"Authorization": {"Bearer token"}, "X-Custom": {"a"}},
+		},
+		{
+			config: "headers:\n  - 'Authorization: Bearer token'\n  - 'X-Custom:a'\n  - invalid",
+			header: http.Header{"Authorization": {"Bearer token"}, "X-Custom": {"a"}},
+		},
+		{
+			config: "headers: token",
+		},
+	}
+
+	for _, tt := range tests {
+		var source FileSource
+		if err := yaml.Unmarshal([]byte(tt.config), &source); err != nil {
+			t.Errorf("bad error (%q): want nil, got %v", tt.config, err)
+			continue
+		}
+		if header := source.Headers.Header(); !reflect.DeepEqual(tt.header, header) {
+			t.Errorf("bad header (%q): want %v, got %v", tt.config, tt.header, header)
+		}
+	}
+}
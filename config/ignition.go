@@ -16,6 +16,10 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 )
 
 func IsIgnitionConfig(userdata string) bool {
@@ -27,3 +31,273 @@ func IsIgnitionConfig(userdata string) bool {
 	}
 	return (json.Unmarshal([]byte(userdata), &cfg) == nil && (cfg.Version != nil || cfg.Ignition.Version != nil))
 }
+
+// Ignition is the subset of an Ignition config, of spec version 2 or 3,
+// which can be applied once the system has booted.
+type Ignition struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	Storage struct {
+		Files       []IgnitionFile      `json:"files"`
+		Directories []IgnitionDirectory `json:"directories"`
+		Links       []IgnitionLink      `json:"links"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []IgnitionUnit `json:"units"`
+	} `json:"systemd"`
+	Passwd struct {
+		Users []IgnitionUser `json:"users"`
+	} `json:"passwd"`
+}
+
+// IgnitionNode holds the fields shared by files, directories and links.
+type IgnitionNode struct {
+	// Filesystem is the name of the filesystem of version 2, "root" for
+	// the root filesystem.
+	Filesystem string        `json:"filesystem"`
+	Path       string        `json:"path"`
+	User       IgnitionOwner `json:"user"`
+	Group      IgnitionOwner `json:"group"`
+	Overwrite  *bool         `json:"overwrite"`
+}
+
+// IgnitionOwner is a user or a group, given by ID or name.
+type IgnitionOwner struct {
+	ID   *int   `json:"id"`
+	Name string `json:"name"`
+}
+
+func (o IgnitionOwner) String() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	if o.ID != nil {
+		return fmt.Sprint(*o.ID)
+	}
+	return ""
+}
+
+// OnRoot reports whether the node is on the root filesystem, which all nodes
+// of version 3 are.
+func (n IgnitionNode) OnRoot() bool {
+	return n.Filesystem == "" || n.Filesystem == "root"
+}
+
+// Owner returns the owner of the node in the form used by chown, e.g.
+// "core:core", "1000" or ":docker", or "" if neither is set.
+func (n IgnitionNode) Owner() string {
+	user, group := n.User.String(), n.Group.String()
+	if group == "" {
+		return user
+	}
+	return user + ":" + group
+}
+
+type IgnitionFile struct {
+	IgnitionNode
+	Contents IgnitionResource `json:"contents"`
+	Mode     *int             `json:"mode"`
+	// Append is a list of resources in version 3, and a boolean in
+	// version 2.
+	Append json.RawMessage `json:"append"`
+}
+
+type IgnitionResource struct {
+	Source       string `json:"source"`
+	Compression  string `json:"compression"`
+	Verification struct {
+		Hash string `json:"hash"`
+	} `json:"verification"`
+}
+
+type IgnitionDirectory struct {
+	IgnitionNode
+	Mode *int `json:"mode"`
+}
+
+type IgnitionLink struct {
+	IgnitionNode
+	Target string `json:"target"`
+	Hard   bool   `json:"hard"`
+}
+
+type IgnitionUnit struct {
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+	// Enable is the deprecated form of Enabled of version 2.
+	Enable  bool           `json:"enable"`
+	Enabled *bool          `json:"enabled"`
+	Mask    bool           `json:"mask"`
+	Dropins []IgnitionUnit `json:"dropins"`
+}
+
+type IgnitionUser struct {
+	Name              string   `json:"name"`
+	PasswordHash      *string  `json:"passwordHash"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys"`
+	Gecos             string   `json:"gecos"`
+	HomeDir           string   `json:"homeDir"`
+	NoCreateHome      bool     `json:"noCreateHome"`
+	PrimaryGroup      string   `json:"primaryGroup"`
+	Groups            []string `json:"groups"`
+	NoUserGroup       bool     `json:"noUserGroup"`
+	NoLogInit         bool     `json:"noLogInit"`
+	System            bool     `json:"system"`
+	Shell             string   `json:"shell"`
+}
+
+// ignitionSupported lists the keys of the Ignition configs which are
+// applied. A key mapped to true is supported along with everything below it.
+var ignitionSupported = map[string]bool{
+	"ignition":                            false,
+	"ignition.version":                    true,
+	"storage":                             false,
+	"storage.files":                       false,
+	"storage.files.path":                  true,
+	"storage.files.user":                  true,
+	"storage.files.group":                 true,
+	"storage.files.mode":                  true,
+	"storage.files.overwrite":             true,
+	"storage.files.filesystem":            true,
+	"storage.files.contents":              false,
+	"storage.files.contents.source":       true,
+	"storage.files.contents.compression":  true,
+	"storage.files.contents.verification": true,
+	"storage.files.append":                false,
+	"storage.files.append.source":         true,
+	"storage.files.append.compression":    true,
+	"storage.files.append.verification":   true,
+	"storage.directories":                 false,
+	"storage.directories.path":            true,
+	"storage.directories.user":            true,
+	"storage.directories.group":           true,
+	"storage.directories.mode":            true,
+	"storage.directories.overwrite":       true,
+	"storage.directories.filesystem":      true,
+	"storage.links":                       false,
+	"storage.links.path":                  true,
+	"storage.links.user":                  true,
+	"storage.links.group":                 true,
+	"storage.links.target":                true,
+	"storage.links.hard":                  true,
+	"storage.links.overwrite":             true,
+	"storage.links.filesystem":            true,
+	"systemd":                             false,
+	"systemd.units":                       true,
+	"passwd":                              false,
+	"passwd.users":                        false,
+	"passwd.users.name":                   true,
+	"passwd.users.passwordHash":           true,
+	"passwd.users.sshAuthorizedKeys":      true,
+	"passwd.users.gecos":                  true,
+	"passwd.users.homeDir":                true,
+	"passwd.users.noCreateHome":           true,
+	"passwd.users.primaryGroup":           true,
+	"passwd.users.groups":                 true,
+	"passwd.users.noUserGroup":            true,
+	"passwd.users.noLogInit":              true,
+	"passwd.users.system":                 true,
+	"passwd.users.shell":                  true,
+	"ignitionVersion":                     true,
+}
+
+// ErrLegacyIgnition is returned by NewIgnition for the configs of spec
+// version 1, which only have an "ignitionVersion" and are left to Ignition.
+var ErrLegacyIgnition = errors.New("Ignition configs of spec version 1 are not supported")
+
+// NewIgnition parses an Ignition config of spec version 2 or 3.
+func NewIgnition(contents string) (*Ignition, error) {
+	var ign Ignition
+	if err := json.Unmarshal([]byte(contents), &ign); err != nil {
+		return nil, err
+	}
+	if ign.Ignition.Version == "" {
+		return nil, ErrLegacyIgnition
+	}
+	major, _, _ := strings.Cut(ign.Ignition.Version, ".")
+	if major != "2" && major != "3" {
+		return nil, fmt.Errorf("unsupported Ignition config version %q", ign.Ignition.Version)
+	}
+	return &ign, nil
+}
+
+// Version returns the major version of the spec of the config.
+func (ign *Ignition) Version() int {
+	if strings.HasPrefix(ign.Ignition.Version, "2.") {
+		return 2
+	}
+	return 3
+}
+
+// Appends returns whether the file is appended to and, in version 3, the
+// resources appended to it. In version 2, Append is a boolean and it's the
+// contents which are appended.
+func (f IgnitionFile) Appends() (bool, []IgnitionResource, error) {
+	if len(f.Append) == 0 || string(f.Append) == "null" {
+		return false, nil, nil
+	}
+	var appending bool
+	if json.Unmarshal(f.Append, &appending) == nil {
+		return appending, nil, nil
+	}
+	var resources []IgnitionResource
+	if err := json.Unmarshal(f.Append, &resources); err != nil {
+		return false, nil, fmt.Errorf("invalid append: %w", err)
+	}
+	return len(resources) > 0, resources, nil
+}
+
+// IsEnabled reports whether the unit is to be enabled.
+func (u IgnitionUnit) IsEnabled() bool {
+	if u.Enabled != nil {
+		return *u.Enabled
+	}
+	return u.Enable
+}
+
+// UnsupportedIgnitionKeys returns, sorted, the keys set in the Ignition
+// config which are not applied, e.g. "storage.disks" or
+// "passwd.users.uid", and the filesystems other than "root" of version 2.
+func UnsupportedIgnitionKeys(contents string) ([]string, error) {
+	var cfg interface{}
+	if err := json.Unmarshal([]byte(contents), &cfg); err != nil {
+		return nil, err
+	}
+
+	unsupported := map[string]struct{}{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				key := k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+				all, ok := ignitionSupported[key]
+				filesystem, _ := child.(string)
+				switch {
+				case strings.HasSuffix(key, ".filesystem") && filesystem != "root":
+					unsupported[fmt.Sprintf("%s (%s)", key, filesystem)] = struct{}{}
+				case !ok:
+					unsupported[key] = struct{}{}
+				case !all:
+					walk(key, child)
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(prefix, child)
+			}
+		}
+	}
+	walk("", cfg)
+
+	keys := make([]string, 0, len(unsupported))
+	for k := range unsupported {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
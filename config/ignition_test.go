@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewIgnition(t *testing.T) {
+	for _, tt := range []struct {
+		contents string
+		version  int
+		err      error
+	}{
+		{`{"ignition": {"version": "2.2.0"}}`, 2, nil},
+		{`{"ignition": {"version": "3.4.0"}}`, 3, nil},
+		{`{"ignitionVersion": 1}`, 0, ErrLegacyIgnition},
+		{`{"ignition": {"version": "4.0.0"}}`, 0, errors.New(`unsupported Ignition config version "4.0.0"`)},
+	} {
+		ign, err := NewIgnition(tt.contents)
+		if tt.err != nil {
+			if err == nil || err.Error() != tt.err.Error() {
+				t.Errorf("bad error (%s): want %v, got %v", tt.contents, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("bad error (%s): want nil, got %v", tt.contents, err)
+		} else if ign.Version() != tt.version {
+			t.Errorf("bad version (%s): want %d, got %d", tt.contents, tt.version, ign.Version())
+		}
+	}
+}
+
+func TestIgnitionFileAppends(t *testing.T) {
+	ign, err := NewIgnition(`{
+  "ignition": {"version": "3.0.0"},
+  "storage": {"files": [
+    {"path": "/a", "append": [{"source": "data:,x"}]},
+    {"path": "/b", "append": true, "user": {"id": 0}, "group": {"name": "core"}},
+    {"path": "/c", "user": {"name": "core"}}
+  ]}
+}`)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+
+	for i, tt := range []struct {
+		appending bool
+		sources   []string
+		owner     string
+	}{
+		{true, []string{"data:,x"}, ""},
+		{true, nil, "0:core"},
+		{false, nil, "core"},
+	} {
+		f := ign.Storage.Files[i]
+		appending, appends, err := f.Appends()
+		var sources []string
+		for _, r := range appends {
+			sources = append(sources, r.Source)
+		}
+		if err != nil || appending != tt.appending || !reflect.DeepEqual(sources, tt.sources) {
+			t.Errorf("bad appends (%s): want %t %q, got %t %q (%v)", f.Path, tt.appending, tt.sources, appending, sources, err)
+		}
+		if f.Owner() != tt.owner {
+			t.Errorf("bad owner (%s): want %q, got %q", f.Path, tt.owner, f.Owner())
+		}
+	}
+}
+
+func TestUnsupportedIgnitionKeys(t *testing.T) {
+	unsupported, err := UnsupportedIgnitionKeys(`{
+  "ignition": {"version": "2.3.0", "config": {"append": []}},
+  "storage": {
+    "disks": [],
+    "files": [
+      {"filesystem": "root", "path": "/a", "contents": {"source": "data:,"}},
+      {"filesystem": "oem", "path": "/b", "contents": {"source": "data:,"}}
+    ]
+  },
+  "systemd": {"units": [{"name": "a.service", "enabled": true}]},
+  "passwd": {"users": [{"name": "core", "uid": 500}], "groups": []}
+}`)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	want := []string{"ignition.config", "passwd.groups", "passwd.users.uid", "storage.disks", "storage.files.filesystem (oem)"}
+	if !reflect.DeepEqual(want, unsupported) {
+		t.Errorf("bad unsupported keys: want %q, got %q", want, unsupported)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Include is a list of URLs whose contents are user-data themselves. With
+// Once set, they are only fetched on the first boot of an instance.
+type Include struct {
+	Once bool
+	URLs []string
+}
+
+func includeHeader(userdata string) string {
+	header := strings.SplitN(userdata, "\n", 2)[0]
+	return strings.TrimRightFunc(header, unicode.IsSpace)
+}
+
+func IsInclude(userdata string) bool {
+	header := includeHeader(userdata)
+	return header == "#include" || header == "#include-once"
+}
+
+// NewInclude parses an "#include" or "#include-once" user-data. Every
+// following line which is neither blank nor a comment is a URL.
+func NewInclude(userdata string) *Include {
+	include := Include{Once: includeHeader(userdata) == "#include-once"}
+	for _, line := range strings.Split(userdata, "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		include.URLs = append(include.URLs, line)
+	}
+	return &include
+}
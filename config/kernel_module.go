@@ -0,0 +1,25 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// KernelModules are the modules of the kernel_modules section.
+type KernelModules []KernelModule
+
+// KernelModule is a module loaded on boot, along with its parameters, e.g.
+// "max_loop=64".
+type KernelModule struct {
+	Name    string `yaml:"name"    valid:"^[A-Za-z0-9_-]+$"`
+	Options string `yaml:"options"`
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MergeHow describes how a cloud-config is merged into the ones before it,
+// following the "merge_how" setting of cloud-init.
+type MergeHow struct {
+	// List is one of "replace", "append", "prepend" or "no_replace".
+	List string
+	// DictNoReplace keeps the existing value of keys present in both.
+	DictNoReplace bool
+	// DictRecurseList merges lists found under the same key according to
+	// List instead of replacing them.
+	DictRecurseList bool
+	// DictRecurseStr merges strings found under the same key according to
+	// Str instead of replacing them.
+	DictRecurseStr bool
+	// Str is either "replace" or "append".
+	Str string
+}
+
+// DefaultMergeHow is the strategy of cloud-configs without "merge_how",
+// "list(append)+dict(recurse_list)+str()". It keeps the items of every list,
+// as if each cloud-config was applied on its own.
+var DefaultMergeHow = MergeHow{List: "append", DictRecurseList: true, Str: "replace"}
+
+// ParseMergeHow parses a merge strategy in the string form of cloud-init,
+// e.g. "list(append)+dict(no_replace,recurse_list)+str()". The options left
+// out are those of cloud-init, "list()+dict()+str()".
+func ParseMergeHow(s string) (MergeHow, error) {
+	how := MergeHow{List: "replace", Str: "replace"}
+	for _, merger := range strings.Split(s, "+") {
+		merger = strings.TrimSpace(merger)
+		if merger == "" {
+			continue
+		}
+		name, opts, ok := strings.Cut(merger, "(")
+		if ok {
+			if !strings.HasSuffix(opts, ")") {
+				return MergeHow{}, fmt.Errorf("invalid merger %q", merger)
+			}
+			opts = strings.TrimSuffix(opts, ")")
+		}
+		if name != "list" && name != "dict" && name != "str" {
+			return MergeHow{}, fmt.Errorf("unknown merger %q", name)
+		}
+
+		for _, opt := range strings.Split(opts, ",") {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "":
+			case name == "list" && (opt == "append" || opt == "prepend" || opt == "replace" || opt == "no_replace"):
+				how.List = opt
+			case name == "list" && (opt == "recurse_array" || opt == "recurse_list" || opt == "recurse_dict" || opt == "recurse_str"):
+				// Lists of lists or dicts are never merged element-wise.
+			case name == "dict" && opt == "replace":
+				how.DictNoReplace = false
+			case name == "dict" && opt == "no_replace":
+				how.DictNoReplace = true
+			case name == "dict" && (opt == "recurse_array" || opt == "recurse_list"):
+				how.DictRecurseList = true
+			case name == "dict" && opt == "recurse_str":
+				how.DictRecurseStr = true
+			case name == "dict" && opt == "recurse_dict":
+				// Nested dicts are always merged recursively.
+			case name == "str" && (opt == "append" || opt == "replace"):
+				how.Str = opt
+			default:
+				return MergeHow{}, fmt.Errorf("unknown option %q for merger %q", opt, name)
+			}
+		}
+	}
+	return how, nil
+}
+
+// MergeCloudConfigs merges the YAML documents of cloud-configs in order. Each
+// document is merged into the previous ones according to its own
+// "merge_how" key or, if it has none, the corresponding entry of how. The
+// "merge_how" keys are removed from the result.
+func MergeCloudConfigs(docs []string, how []MergeHow) (string, error) {
+	var merged *yaml.Node
+	for i, doc := range docs {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(doc), &node); err != nil {
+			return "", err
+		}
+		root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if len(node.Content) > 0 && node.Content[0].Kind == yaml.MappingNode {
+			root = node.Content[0]
+		} else if len(node.Content) > 0 && node.Content[0].Tag != "!!null" {
+			return "", fmt.Errorf("cloud-config %d is not a mapping", i+1)
+		}
+
+		h := DefaultMergeHow
+		if i < len(how) {
+			h = how[i]
+		}
+		if v := removeKey(root, "merge_how"); v != nil {
+			if v.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("cloud-config %d: merge_how must be a string, e.g. \"list(append)+dict(recurse_list)+str()\"", i+1)
+			}
+			var err error
+			if h, err = ParseMergeHow(v.Value); err != nil {
+				return "", fmt.Errorf("cloud-config %d: invalid merge_how: %w", i+1, err)
+			}
+		}
+
+		if merged == nil {
+			merged = root
+		} else {
+			mergeNodes(merged, root, h)
+		}
+	}
+	if merged == nil {
+		return "", nil
+	}
+	// Comments, like the "#cloud-config" headers, don't survive merging.
+	stripComments(merged)
+
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return "#cloud-config\n" + string(out), nil
+}
+
+// SummarizeCloudConfig describes a cloud-config by its keys, the length of
+// its lists and the keys of its nested dicts, e.g.
+// "hostname, coreos(units[2]), write_files[3]".
+func SummarizeCloudConfig(doc string) string {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(doc), &node); err != nil || len(node.Content) == 0 {
+		return ""
+	}
+	return summarize(node.Content[0], 2)
+}
+
+func summarize(n *yaml.Node, depth int) string {
+	switch {
+	case n.Kind == yaml.SequenceNode:
+		return fmt.Sprintf("[%d]", len(n.Content))
+	case n.Kind != yaml.MappingNode || depth == 0:
+		return ""
+	}
+
+	var keys []string
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		s := n.Content[i].Value
+		if sub := summarize(n.Content[i+1], depth-1); sub != "" && n.Content[i+1].Kind == yaml.MappingNode {
+			s += "(" + sub + ")"
+		} else {
+			s += sub
+		}
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+func stripComments(n *yaml.Node) {
+	n.HeadComment, n.LineComment, n.FootComment = "", "", ""
+	for _, c := range n.Content {
+		stripComments(c)
+	}
+}
+
+func removeKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+func mergeNodes(dst, src *yaml.Node, how MergeHow) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		j := 0
+		for ; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				break
+			}
+		}
+		if j+1 >= len(dst.Content) {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		old := dst.Content[j+1]
+		switch {
+		case old.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(old, value, how)
+		case how.DictNoReplace:
+		case old.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode && how.DictRecurseList:
+			old.Content = mergeLists(old.Content, value.Content, how.List)
+		case old.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode && how.DictRecurseStr && how.Str == "append":
+			old.Value += value.Value
+		default:
+			dst.Content[j+1] = value
+		}
+	}
+}
+
+// mergeLists merges two lists. When appending, an item with the same name
+// or path as an earlier one replaces it, so that e.g. a unit or a user is
+// only defined once.
+func mergeLists(dst, src []*yaml.Node, method string) []*yaml.Node {
+	switch method {
+	case "prepend":
+		return append(append([]*yaml.Node{}, src...), dst...)
+	case "no_replace":
+		return dst
+	case "append":
+	default:
+		return src
+	}
+
+	merged := append([]*yaml.Node{}, dst...)
+	for _, item := range src {
+		replaced := false
+		if id := itemIdentity(item); id != "" {
+			for i, existing := range merged {
+				if itemIdentity(existing) == id {
+					merged[i] = item
+					replaced = true
+					break
+				}
+			}
+		}
+		if !replaced {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+func itemIdentity(item *yaml.Node) string {
+	if item.Kind != yaml.MappingNode {
+		return ""
+	}
+	for _, key := range []string{"name", "path"} {
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			if item.Content[i].Value == key && item.Content[i+1].Kind == yaml.ScalarNode {
+				return key + "=" + item.Content[i+1].Value
+			}
+		}
+	}
+	return ""
+}
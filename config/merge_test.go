@@ -0,0 +1,141 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMergeHow(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out MergeHow
+		err bool
+	}{
+		{"", MergeHow{List: "replace", Str: "replace"}, false},
+		{"list()+dict()+str()", MergeHow{List: "replace", Str: "replace"}, false},
+		{"list(append)+dict(recurse_list)+str()", DefaultMergeHow, false},
+		{"list(append)+dict(recurse_array)+str()", MergeHow{List: "append", DictRecurseList: true, Str: "replace"}, false},
+		{"dict(no_replace,recurse_list)", MergeHow{List: "replace", DictNoReplace: true, DictRecurseList: true, Str: "replace"}, false},
+		{"list(prepend) + dict(recurse_str) + str(append)", MergeHow{List: "prepend", DictRecurseStr: true, Str: "append"}, false},
+		{"set()", MergeHow{}, true},
+		{"list(merge)", MergeHow{}, true},
+		{"list(append", MergeHow{}, true},
+	} {
+		out, err := ParseMergeHow(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (%q): want %t, got %v", tt.in, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("bad result (%q): want %#v, got %#v", tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestMergeCloudConfigs(t *testing.T) {
+	appendLists := MergeHow{List: "append", DictRecurseList: true, Str: "replace"}
+
+	for _, tt := range []struct {
+		docs []string
+		how  []MergeHow
+		out  string
+	}{
+		{
+			// Later values replace earlier ones, nested dicts are merged
+			// and lists are appended.
+			docs: []string{
+				"#cloud-config\nhostname: first\nssh_authorized_keys: [a]\ncoreos:\n  update:\n    group: alpha\n",
+				"#cloud-config\nhostname: second\nssh_authorized_keys: [b]\ncoreos:\n  update:\n    server: https://example.com\n",
+			},
+			out: "#cloud-config\nhostname: second\nssh_authorized_keys: [a, b]\ncoreos:\n    update:\n        group: alpha\n        server: https://example.com\n",
+		},
+		{
+			// The files, units and users of every part are kept.
+			docs: []string{
+				"#cloud-config\nwrite_files:\n  - path: /a\ncoreos:\n  units:\n    - name: a.service\nusers:\n  - name: a\n",
+				"#cloud-config\nwrite_files:\n  - path: /b\ncoreos:\n  units:\n    - name: b.service\nusers:\n  - name: b\n",
+			},
+			out: "#cloud-config\nwrite_files:\n    - path: /a\n    - path: /b\ncoreos:\n    units:\n        - name: a.service\n        - name: b.service\nusers:\n    - name: a\n    - name: b\n",
+		},
+		{
+			docs: []string{
+				"#cloud-config\nhostname: first\n",
+				"#cloud-config\nhostname: second\nmerge_how: dict(no_replace)\n",
+			},
+			out: "#cloud-config\nhostname: first\n",
+		},
+		{
+			// Appended items replace earlier ones with the same name or path.
+			docs: []string{
+				"#cloud-config\nwrite_files:\n  - path: /a\n    permissions: 0644\n    content: a\n",
+				"#cloud-config\nwrite_files:\n  - path: /b\n    content: b\n  - path: /a\n    content: c\n",
+			},
+			out: "#cloud-config\nwrite_files:\n    - path: /a\n      content: c\n    - path: /b\n      content: b\n",
+		},
+		{
+			docs: []string{
+				"#cloud-config\nssh_authorized_keys: [a]\n",
+				"#cloud-config\nmerge_how: list(prepend)+dict(recurse_list)\nssh_authorized_keys: [b]\n",
+				"#cloud-config\n",
+			},
+			how: []MergeHow{DefaultMergeHow, appendLists, appendLists},
+			out: "#cloud-config\nssh_authorized_keys: [b, a]\n",
+		},
+		{
+			// Without dict(recurse_list), lists are replaced.
+			docs: []string{
+				"#cloud-config\nssh_authorized_keys: [a]\n",
+				"#cloud-config\nmerge_how: list(append)\nssh_authorized_keys: [b]\n",
+			},
+			out: "#cloud-config\nssh_authorized_keys: [b]\n",
+		},
+		{
+			docs: []string{
+				"#cloud-config\nssh_authorized_keys: [a]\n",
+				"#cloud-config\nssh_authorized_keys: [b]\n",
+			},
+			how: []MergeHow{DefaultMergeHow, {List: "replace", Str: "replace"}},
+			out: "#cloud-config\nssh_authorized_keys: [b]\n",
+		},
+	} {
+		out, err := MergeCloudConfigs(tt.docs, tt.how)
+		if err != nil {
+			t.Errorf("bad error (%q): %v", tt.docs, err)
+		}
+		if out != tt.out {
+			t.Errorf("bad result (%q): want %q, got %q", tt.docs, tt.out, out)
+		}
+	}
+}
+
+func TestMergeCloudConfigsInvalidMergeHow(t *testing.T) {
+	for _, merge := range []string{
+		"merge_how: set()",
+		"merge_how:\n  - name: list\n    settings: [append]",
+	} {
+		docs := []string{"#cloud-config\n", "#cloud-config\n" + merge + "\n"}
+		if _, err := MergeCloudConfigs(docs, nil); err == nil {
+			t.Errorf("bad error (%q): want non-nil, got nil", merge)
+		}
+	}
+}
+
+func TestSummarizeCloudConfig(t *testing.T) {
+	doc := "#cloud-config\nhostname: a\nwrite_files: [{path: /a}, {path: /b}]\ncoreos:\n  units: [{name: a}]\n  update:\n    group: alpha\n"
+	if s := SummarizeCloudConfig(doc); s != "coreos(units[1], update), hostname, write_files[2]" {
+		t.Errorf("bad summary: %q", s)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// NTP configures the time synchronization of the system. Enabled is a string
+// so that the client is only disabled when explicitly asked for.
+type NTP struct {
+	Enabled         string   `yaml:"enabled"          valid:"^(true|false)$"`
+	Client          string   `yaml:"ntp_client"       valid:"^(systemd-timesyncd|chrony)$"`
+	Servers         []string `yaml:"servers"`
+	FallbackServers []string `yaml:"fallback_servers"`
+	Pools           []string `yaml:"pools"`
+}
@@ -0,0 +1,26 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// Resolved holds the global settings of systemd-resolved. The DNS servers
+// take the forms of resolved.conf, e.g. "192.0.2.1", "192.0.2.1:53" or
+// "[2001:db8::1]:853#dns.example.com".
+type Resolved struct {
+	DNS         []string `yaml:"dns"`
+	FallbackDNS []string `yaml:"fallback_dns"`
+	Domains     []string `yaml:"domains"`
+	DNSSEC      string   `yaml:"dnssec"       valid:"^(true|false|allow-downgrade)$"`
+	DNSOverTLS  string   `yaml:"dns_over_tls" valid:"^(true|false|opportunistic)$"`
+}
@@ -15,16 +15,94 @@
 package config
 
 import (
+	"fmt"
 	"strings"
+	"unicode"
 )
 
 type Script []byte
 
+// ScriptOptions configures the transient unit in which a user-data script is
+// run. They are given by the "scripts" section of the cloud-config and
+// overridden by the X-Script-* headers of the MIME part of the script.
+type ScriptOptions struct {
+	// Interpreter runs the script instead of the command of its "#!" line.
+	Interpreter string `yaml:"interpreter"`
+	// Environment holds variables of the form KEY=VALUE.
+	Environment      []string `yaml:"environment"`
+	User             string   `yaml:"user"`
+	WorkingDirectory string   `yaml:"working_directory"`
+	// Timeout is a duration such as "90s" or "5m".
+	Timeout         string `yaml:"timeout"           valid:"^([0-9]+(\\.[0-9]+)?(ns|us|ms|s|m|h))+$"`
+	ProtectSystem   string `yaml:"protect_system"    valid:"^(true|false|full|strict)$"`
+	ProtectHome     string `yaml:"protect_home"      valid:"^(true|false|read-only|tmpfs)$"`
+	PrivateTmp      bool   `yaml:"private_tmp"`
+	PrivateNetwork  bool   `yaml:"private_network"`
+	PrivateDevices  bool   `yaml:"private_devices"`
+	NoNewPrivileges bool   `yaml:"no_new_privileges"`
+}
+
+// Override returns the options with those set in o replaced by those set in
+// other. The environments are concatenated.
+func (o ScriptOptions) Override(other ScriptOptions) ScriptOptions {
+	if other.Interpreter != "" {
+		o.Interpreter = other.Interpreter
+	}
+	o.Environment = append(append([]string{}, o.Environment...), other.Environment...)
+	if other.User != "" {
+		o.User = other.User
+	}
+	if other.WorkingDirectory != "" {
+		o.WorkingDirectory = other.WorkingDirectory
+	}
+	if other.Timeout != "" {
+		o.Timeout = other.Timeout
+	}
+	if other.ProtectSystem != "" {
+		o.ProtectSystem = other.ProtectSystem
+	}
+	if other.ProtectHome != "" {
+		o.ProtectHome = other.ProtectHome
+	}
+	o.PrivateTmp = o.PrivateTmp || other.PrivateTmp
+	o.PrivateNetwork = o.PrivateNetwork || other.PrivateNetwork
+	o.PrivateDevices = o.PrivateDevices || other.PrivateDevices
+	o.NoNewPrivileges = o.NoNewPrivileges || other.NoNewPrivileges
+	return o
+}
+
+// Validate checks the values of the options.
+func (o ScriptOptions) Validate() error {
+	if err := AssertStructValid(o); err != nil {
+		return err
+	}
+	for _, v := range o.Environment {
+		if k, _, ok := strings.Cut(v, "="); !ok || k == "" {
+			return fmt.Errorf("invalid environment variable %q (want KEY=VALUE)", v)
+		}
+	}
+	return nil
+}
+
 func IsScript(userdata string) bool {
 	header := strings.SplitN(userdata, "\n", 2)[0]
 	return strings.HasPrefix(header, "#!")
 }
 
+func IsBoothook(userdata string) bool {
+	header := strings.SplitN(userdata, "\n", 2)[0]
+	return strings.TrimRightFunc(header, unicode.IsSpace) == "#cloud-boothook"
+}
+
+// NewBoothook returns the script of a boothook, without the
+// "#cloud-boothook" line.
+func NewBoothook(userdata string) (*Script, error) {
+	if IsBoothook(userdata) {
+		_, userdata, _ = strings.Cut(userdata, "\n")
+	}
+	return NewScript(userdata)
+}
+
 func NewScript(userdata string) (*Script, error) {
 	s := Script(userdata)
 	return &s, nil
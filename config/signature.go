@@ -0,0 +1,188 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// SignatureHeader is the MIME part header carrying the detached
+	// signature of the part, in the form "ed25519=<base64>". See
+	// SignedData for what it covers.
+	SignatureHeader = "X-Cloudinit-Signature"
+
+	// DefaultTrustedKeysDir is the directory holding the public keys allowed
+	// to sign user-data.
+	DefaultTrustedKeysDir = "/usr/share/coreos-cloudinit/trusted.d"
+)
+
+var ErrUnsigned = errors.New("no signature found")
+
+// TrustedKeys is the set of public keys allowed to sign user-data.
+type TrustedKeys []ed25519.PublicKey
+
+// LoadTrustedKeys reads every file in dir. Each file may contain PEM encoded
+// "PUBLIC KEY" blocks or OpenSSH "ssh-ed25519" public key lines.
+func LoadTrustedKeys(dir string) (TrustedKeys, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := TrustedKeys{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key file %q: %w", name, err)
+		}
+		keys = append(keys, parsed...)
+	}
+	return keys, nil
+}
+
+func parsePublicKeys(data []byte) (TrustedKeys, error) {
+	keys := TrustedKeys{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ed, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+		keys = append(keys, ed)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "ssh-ed25519" {
+			continue
+		}
+		key, err := parseSSHEd25519(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseSSHEd25519 decodes the base64 wire format of an OpenSSH ed25519 key:
+// the string "ssh-ed25519" followed by the 32 byte key, each length prefixed.
+func parseSSHEd25519(encoded string) (ed25519.PublicKey, error) {
+	wire, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields [][]byte
+	for len(wire) >= 4 {
+		n := binary.BigEndian.Uint32(wire)
+		if uint32(len(wire)-4) < n {
+			break
+		}
+		fields = append(fields, wire[4:4+n])
+		wire = wire[4+n:]
+	}
+	if len(fields) != 2 || !bytes.Equal(fields[0], []byte("ssh-ed25519")) || len(fields[1]) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed ssh-ed25519 key")
+	}
+	return ed25519.PublicKey(fields[1]), nil
+}
+
+// SignedData returns the data covered by the signature of a MIME part: a
+// "name: value" line for each value of its headers but SignatureHeader and
+// Content-Transfer-Encoding, with the names lowercased and sorted, then an
+// empty line and the body with the Content-Transfer-Encoding removed. Thus
+// neither the type of the part nor the options given in its headers can be
+// changed without invalidating the signature.
+func SignedData(header textproto.MIMEHeader, body []byte) []byte {
+	var names []string
+	for name := range header {
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case SignatureHeader, "Content-Transfer-Encoding":
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(buf, "%s: %s\n", strings.ToLower(name), strings.TrimSpace(value))
+		}
+	}
+	buf.WriteString("\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// Verify checks that signature, in the form used by SignatureHeader, is a
+// valid signature of data by one of the trusted keys.
+func (k TrustedKeys) Verify(data []byte, signature string) error {
+	if signature == "" {
+		return ErrUnsigned
+	}
+
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(signature), "=")
+	if !ok {
+		return fmt.Errorf("malformed signature %q", signature)
+	}
+	if algorithm != "ed25519" {
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed ed25519 signature")
+	}
+
+	for _, key := range k {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any trusted key")
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"net/textproto"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadTrustedKeys(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire := []byte{}
+	for _, field := range [][]byte{[]byte("ssh-ed25519"), pub} {
+		wire = binary.BigEndian.AppendUint32(wire, uint32(len(field)))
+		wire = append(wire, field...)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	os.WriteFile(path.Join(dir, "key.pub"), []byte("ssh-ed25519 "+base64.StdEncoding.EncodeToString(wire)+" ops@example.com\n"), 0644)
+
+	keys, err := LoadTrustedKeys(dir)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(pub) || !keys[1].Equal(pub) {
+		t.Errorf("bad keys: want 2 copies of %x, got %x", pub, keys)
+	}
+
+	os.WriteFile(path.Join(dir, "broken.pub"), []byte("ssh-ed25519 AAAA\n"), 0644)
+	if _, err := LoadTrustedKeys(dir); err == nil {
+		t.Errorf("bad error: want non-nil, got nil")
+	}
+}
+
+func TestTrustedKeysVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("#cloud-config\n")
+	signature := "ed25519=" + base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+
+	for _, tt := range []struct {
+		keys      TrustedKeys
+		data      []byte
+		signature string
+
+		valid bool
+	}{
+		{TrustedKeys{pub}, data, signature, true},
+		{TrustedKeys{other, pub}, data, signature, true},
+		{TrustedKeys{other}, data, signature, false},
+		{TrustedKeys{pub}, []byte("#cloud-config\nhostname: evil\n"), signature, false},
+		{TrustedKeys{pub}, data, "", false},
+		{TrustedKeys{pub}, data, "pgp=abcd", false},
+		{TrustedKeys{pub}, data, "ed25519=abcd", false},
+		{TrustedKeys{}, data, signature, false},
+	} {
+		if err := tt.keys.Verify(tt.data, tt.signature); (err == nil) != tt.valid {
+			t.Errorf("bad result (%q, %q): want valid=%t, got %v", tt.data, tt.signature, tt.valid, err)
+		}
+	}
+}
+
+func TestSignedData(t *testing.T) {
+	header := textproto.MIMEHeader{
+		"Content-Type":              {"text/x-shellscript"},
+		"X-Script-Environment":      {"A=1", " B=2"},
+		"Content-Transfer-Encoding": {"base64"},
+		"X-Cloudinit-Signature":     {"ed25519=abcd"},
+		"Content-Disposition":       {`attachment; filename="a.sh"`},
+	}
+	want := "content-disposition: attachment; filename=\"a.sh\"\ncontent-type: text/x-shellscript\nx-script-environment: A=1\nx-script-environment: B=2\n\n#!/bin/sh\n"
+	if data := SignedData(header, []byte("#!/bin/sh\n")); string(data) != want {
+		t.Errorf("bad data: want %q, got %q", want, data)
+	}
+	if data := SignedData(textproto.MIMEHeader{}, []byte("body")); string(data) != "\nbody" {
+		t.Errorf("bad data: want %q, got %q", "\nbody", data)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sysctl holds kernel parameters of the form "key=value", given either as a
+// mapping of keys to values, in the order of the cloud-config, or as a list.
+// Parameters of any other form are left out and reported by the validator.
+type Sysctl []string
+
+func (s *Sysctl) UnmarshalYAML(value *yaml.Node) error {
+	*s = nil
+	switch value.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if v := value.Content[i+1]; v.Kind == yaml.ScalarNode {
+				*s = append(*s, value.Content[i].Value+"="+v.Value)
+			}
+		}
+	case yaml.SequenceNode:
+		for _, param := range value.Content {
+			*s = append(*s, param.Value)
+		}
+	}
+	return nil
+}
+
+// Params returns the keys and values of the parameters, skipping those which
+// aren't of the form "key=value".
+func (s Sysctl) Params() [][2]string {
+	var params [][2]string
+	for _, param := range s {
+		key, value, ok := strings.Cut(param, "=")
+		if key = strings.TrimSpace(key); ok && key != "" {
+			params = append(params, [2]string{key, strings.TrimSpace(value)})
+		}
+	}
+	return params
+}
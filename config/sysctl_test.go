@@ -0,0 +1,57 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSysctl(t *testing.T) {
+	tests := []struct {
+		contents string
+
+		sysctl Sysctl
+		params [][2]string
+	}{
+		{
+			contents: "#cloud-config",
+		},
+		{
+			contents: "#cloud-config\nsysctl:\n  vm.swappiness: 10\n  net.ipv4.ip_forward: 1\n  kernel.sysrq: [1]",
+			sysctl:   Sysctl{"vm.swappiness=10", "net.ipv4.ip_forward=1"},
+			params:   [][2]string{{"vm.swappiness", "10"}, {"net.ipv4.ip_forward", "1"}},
+		},
+		{
+			contents: "#cloud-config\nsysctl:\n  - vm.swappiness = 10\n  - -net.ipv4.conf.all.rp_filter=2\n  - kernel.sysrq",
+			sysctl:   Sysctl{"vm.swappiness = 10", "-net.ipv4.conf.all.rp_filter=2", "kernel.sysrq"},
+			params:   [][2]string{{"vm.swappiness", "10"}, {"-net.ipv4.conf.all.rp_filter", "2"}},
+		},
+	}
+
+	for i, tt := range tests {
+		cfg, err := NewCloudConfig(tt.contents)
+		if err != nil {
+			t.Errorf("bad error (test case #%d): want nil, got %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.sysctl, cfg.Sysctl) {
+			t.Errorf("bad sysctl (test case #%d): want %q, got %q", i, tt.sysctl, cfg.Sysctl)
+		}
+		if params := cfg.Sysctl.Params(); !reflect.DeepEqual(tt.params, params) {
+			t.Errorf("bad params (test case #%d): want %q, got %q", i, tt.params, params)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/pkg"
+)
+
+// validateIgnition reports the parts of an Ignition config which can't be
+// applied by coreos-cloudinit.
+func validateIgnition(userdataBytes []byte) Report {
+	report := Report{}
+	ign, err := config.NewIgnition(string(userdataBytes))
+	if errors.Is(err, config.ErrLegacyIgnition) {
+		return report
+	} else if err != nil {
+		report.Error(1, err.Error())
+		return report
+	}
+
+	unsupported, err := config.UnsupportedIgnitionKeys(string(userdataBytes))
+	if err != nil {
+		report.Error(1, err.Error())
+		return report
+	}
+	for _, key := range unsupported {
+		name := key[strings.LastIndex(key, ".")+1:]
+		name, _, _ = strings.Cut(name, " ")
+		report.Warning(jsonKeyLine(userdataBytes, name), fmt.Sprintf("unsupported Ignition key %q is ignored", key))
+	}
+
+	for _, f := range ign.Storage.Files {
+		for _, source := range ignitionSources(f) {
+			if source != "" && !pkg.IsFetchable(source) {
+				report.Error(jsonValueLine(userdataBytes, source), fmt.Sprintf("unsupported source for %q", f.Path))
+			}
+		}
+		if f.Contents.Compression != "" && f.Contents.Compression != "gzip" {
+			report.Error(jsonValueLine(userdataBytes, f.Contents.Source), fmt.Sprintf("unsupported compression %q for %q", f.Contents.Compression, f.Path))
+		}
+	}
+	return report
+}
+
+func ignitionSources(f config.IgnitionFile) []string {
+	sources := []string{f.Contents.Source}
+	_, appends, _ := f.Appends()
+	for _, r := range appends {
+		sources = append(sources, r.Source)
+	}
+	return sources
+}
+
+// jsonKeyLine returns the line of the first occurrence of the key, or 1.
+func jsonKeyLine(data []byte, key string) int {
+	return lineOf(data, fmt.Sprintf("%q", key))
+}
+
+// jsonValueLine returns the line of the first occurrence of the string
+// value, or 1.
+func jsonValueLine(data []byte, value string) int {
+	return lineOf(data, value)
+}
+
+func lineOf(data []byte, s string) int {
+	i := bytes.Index(data, []byte(s))
+	if i < 0 || s == "" {
+		return 1
+	}
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
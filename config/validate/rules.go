@@ -15,17 +15,41 @@
 package validate
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"net/url"
+	"os"
 	"path"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/pkg"
+	"github.com/flatcar/coreos-cloudinit/system"
+
+	"gopkg.in/yaml.v3"
 )
 
 type rule func(config node, report *Report)
 
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// timezoneRoot is the root of the zoneinfo database timezones are checked
+// against.
+var timezoneRoot = "/"
+
+// ownerRoot is the root of the users and groups owners are checked against.
+var ownerRoot = "/"
+
+// sysctlRoot is where the kernel parameters are checked, if it exists.
+var sysctlRoot = "/proc/sys"
+
+// hostnameLabel matches the labels of hostnames allowed by RFC 1123.
+var hostnameLabel = regexp.MustCompile("^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$")
+
 // Rules contains all of the validation rules.
 var Rules []rule = []rule{
 	checkDiscoveryUrl,
@@ -34,6 +58,17 @@ var Rules []rule = []rule{
 	checkValidity,
 	checkWriteFiles,
 	checkWriteFilesUnderCoreos,
+	checkCommands,
+	checkOwners,
+	checkDirectoriesAndLinks,
+	checkTimezone,
+	checkNTP,
+	checkResolved,
+	checkEtcHosts,
+	checkHostname,
+	checkCACerts,
+	checkSysctl,
+	checkKernelModules,
 }
 
 // checkDiscoveryUrl verifies that the string is a valid url.
@@ -58,6 +93,19 @@ func checkEncoding(cfg node, report *Report) {
 		}
 
 		c := f.Child("content")
+		if strings.HasPrefix(e.String(), "age") {
+			// The key is only available on the host, so only check that the
+			// content is encrypted.
+			data := []byte(c.String())
+			var err error
+			if e.String() != "age" {
+				data, err = config.DecodeBase64Content(c.String())
+			}
+			if err != nil || !config.IsAgeEncrypted(data) {
+				report.Error(c.line, fmt.Sprintf("content cannot be decoded as %q", e.String()))
+			}
+			continue
+		}
 		if _, err := config.DecodeContent(c.String(), e.String()); err != nil {
 			report.Error(c.line, fmt.Sprintf("content cannot be decoded as %q", e.String()))
 		}
@@ -73,6 +121,10 @@ func checkStructure(cfg node, report *Report) {
 }
 
 func checkNodeStructure(n, g node, r *Report) {
+	if g.IsValid() && reflect.PtrTo(g.Type()).Implements(unmarshalerType) {
+		// Types decoding themselves have their own rules.
+		return
+	}
 	if !isCompatible(n.Kind(), g.Kind()) {
 		r.Warning(n.line, fmt.Sprintf("incorrect type for %q (want %s)", n.name, g.HumanType()))
 		return
@@ -154,9 +206,14 @@ func checkNodeValidity(n, g node, r *Report) {
 
 // checkWriteFiles checks to make sure that the target file can actually be
 // written. Note that this check is approximate (it only checks to see if the file
-// is under /usr).
+// is under /usr). It also checks that the content of files is either given or
+// downloaded from a valid source.
 func checkWriteFiles(cfg node, report *Report) {
 	for _, f := range cfg.Child("write_files").children {
+		if s := f.Child("source"); s.IsValid() {
+			checkFileSource(f, s, report)
+		}
+
 		c := f.Child("path")
 		if !c.IsValid() {
 			continue
@@ -170,6 +227,40 @@ func checkWriteFiles(cfg node, report *Report) {
 	}
 }
 
+func checkFileSource(f, s node, report *Report) {
+	for _, key := range []string{"content", "encoding"} {
+		if c := f.Child(key); c.IsValid() {
+			report.Error(c.line, fmt.Sprintf("%s cannot be used along with source", key))
+		}
+	}
+
+	if u := s.Child("uri"); !u.IsValid() || fmt.Sprint(u.Interface()) == "" {
+		report.Error(s.line, "source is missing its uri")
+	} else if !pkg.IsFetchable(fmt.Sprint(u.Interface())) {
+		report.Error(u.line, fmt.Sprintf("unsupported source %q", u.Interface()))
+	}
+
+	if c := s.Child("checksum"); c.IsValid() {
+		if _, err := pkg.ParseChecksum(fmt.Sprint(c.Interface())); err != nil {
+			report.Error(c.line, err.Error())
+		}
+	}
+
+	if h := s.Child("headers"); h.IsValid() {
+		switch h.Kind() {
+		case reflect.Map:
+		case reflect.Slice:
+			for _, c := range h.children {
+				if name, _, ok := strings.Cut(fmt.Sprint(c.Interface()), ":"); !ok || strings.TrimSpace(name) == "" {
+					report.Error(c.line, fmt.Sprintf("invalid header %q (want \"Name: value\")", c.Interface()))
+				}
+			}
+		default:
+			report.Error(h.line, "invalid headers (want a mapping or a list of \"Name: value\")")
+		}
+	}
+}
+
 // checkWriteFilesUnderCoreos checks to see if the 'write_files' node is a
 // child of 'coreos' (it shouldn't be).
 func checkWriteFilesUnderCoreos(cfg node, report *Report) {
@@ -178,3 +269,302 @@ func checkWriteFilesUnderCoreos(cfg node, report *Report) {
 		report.Info(c.line, "write_files doesn't belong under coreos")
 	}
 }
+
+// checkCommands verifies that each command of 'bootcmd' and 'runcmd' is
+// either a string or a non-empty list of arguments.
+func checkCommands(cfg node, report *Report) {
+	for _, section := range []string{"bootcmd", "runcmd"} {
+		for _, c := range cfg.Child(section).children {
+			switch c.Kind() {
+			case reflect.String, reflect.Int, reflect.Float64, reflect.Bool:
+			case reflect.Slice:
+				if len(c.children) == 0 {
+					report.Error(c.line, fmt.Sprintf("empty command in %q", section))
+				}
+				for _, arg := range c.children {
+					if !isCompatible(arg.Kind(), reflect.String) {
+						report.Error(c.line, fmt.Sprintf("invalid argument in %q (want string)", section))
+					}
+				}
+			default:
+				report.Error(c.line, fmt.Sprintf("invalid command in %q (want string or list of arguments)", section))
+			}
+		}
+	}
+}
+
+// checkDirectoriesAndLinks verifies that each directory and link has a path,
+// which isn't under /usr or also used by write_files, and that each link has
+// a target.
+func checkDirectoriesAndLinks(cfg node, report *Report) {
+	paths := map[string]string{}
+	for _, f := range cfg.Child("write_files").children {
+		if p := f.Child("path"); p.IsValid() {
+			paths[path.Clean(fmt.Sprint(p.Interface()))] = "write_files"
+		}
+	}
+
+	for _, section := range []string{"directories", "links"} {
+		for _, c := range cfg.Child(section).children {
+			p := c.Child("path")
+			if !p.IsValid() || fmt.Sprint(p.Interface()) == "" {
+				report.Error(c.line, fmt.Sprintf("missing path in %q", section))
+				continue
+			}
+			name := path.Clean(fmt.Sprint(p.Interface()))
+			if other, ok := paths[name]; ok {
+				report.Error(p.line, fmt.Sprintf("path %q is already used in %q", name, other))
+			}
+			paths[name] = section
+			if strings.HasPrefix(path.Dir(name), "/usr") {
+				report.Error(p.line, "path cannot be written to a read-only filesystem")
+			}
+
+			if t := c.Child("target"); section == "links" && (!t.IsValid() || fmt.Sprint(t.Interface()) == "") {
+				report.Error(c.line, "missing target in \"links\"")
+			}
+		}
+	}
+}
+
+// checkOwners verifies that the owners of the files, directories and links
+// exist, if there are users where the validation runs. The users created by
+// the cloud-config, and their login groups, are left out, as are the owners
+// of deferred files, which may be created by the units.
+func checkOwners(cfg node, report *Report) {
+	if _, err := os.Stat(path.Join(ownerRoot, "etc/passwd")); err != nil {
+		return
+	}
+
+	users := map[string]bool{}
+	for _, u := range cfg.Child("users").children {
+		if n := u.Child("name"); n.IsValid() {
+			users[fmt.Sprint(n.Interface())] = true
+		}
+	}
+
+	var owners []node
+	for _, f := range cfg.Child("write_files").children {
+		if d := f.Child("defer"); !d.IsValid() || fmt.Sprint(d.Interface()) != "true" {
+			owners = append(owners, f.Child("owner"))
+		}
+	}
+	for _, section := range []string{"directories", "links"} {
+		for _, c := range cfg.Child(section).children {
+			owners = append(owners, c.Child("owner"))
+		}
+	}
+
+	for _, o := range owners {
+		if !o.IsValid() {
+			continue
+		}
+		user, group, hasGroup := strings.Cut(fmt.Sprint(o.Interface()), ":")
+		if users[user] {
+			user = ""
+		}
+		if users[group] {
+			group = ""
+		}
+		owner := user
+		if hasGroup {
+			owner += ":" + group
+		}
+		if _, _, err := system.LookupOwner(owner, ownerRoot); err != nil {
+			report.Error(o.line, err.Error())
+		}
+	}
+}
+
+// checkTimezone verifies that the timezone is in the zoneinfo database, if
+// there is one where the validation runs.
+func checkTimezone(cfg node, report *Report) {
+	c := cfg.Child("timezone")
+	if !c.IsValid() || fmt.Sprint(c.Interface()) == "" {
+		return
+	}
+	if _, err := os.Stat(path.Join(timezoneRoot, "usr/share/zoneinfo")); err != nil {
+		return
+	}
+	if err := system.CheckTimezone(fmt.Sprint(c.Interface()), timezoneRoot); err != nil {
+		report.Error(c.line, err.Error())
+	}
+}
+
+// checkNTP warns about the options of the ntp section which have no effect.
+func checkNTP(cfg node, report *Report) {
+	n := cfg.Child("ntp")
+	if !n.IsValid() {
+		return
+	}
+	if e := n.Child("enabled"); e.IsValid() && fmt.Sprint(e.Interface()) == "false" {
+		for _, k := range []string{"servers", "fallback_servers", "pools"} {
+			if c := n.Child(k); len(c.children) > 0 {
+				report.Warning(c.line, fmt.Sprintf("%q is ignored since ntp is disabled", k))
+			}
+		}
+		return
+	}
+	if c := n.Child("ntp_client"); c.IsValid() && fmt.Sprint(c.Interface()) == "chrony" {
+		if f := n.Child("fallback_servers"); len(f.children) > 0 {
+			report.Warning(f.line, "fallback_servers are not supported by chrony and are ignored")
+		}
+	}
+}
+
+// checkResolved verifies that the DNS servers of the resolved section have
+// valid IP addresses.
+func checkResolved(cfg node, report *Report) {
+	r := cfg.Child("resolved")
+	for _, k := range []string{"dns", "fallback_dns"} {
+		for _, c := range r.Child(k).children {
+			if err := system.CheckDNSServer(fmt.Sprint(c.Interface())); err != nil {
+				report.Error(c.line, err.Error())
+			}
+		}
+	}
+}
+
+// checkEtcHosts verifies that a template for /etc/hosts is given when
+// manage_etc_hosts is "template" and warns about a template which isn't used.
+func checkEtcHosts(cfg node, report *Report) {
+	m := cfg.Child("manage_etc_hosts")
+	t := cfg.Child("etc_hosts_template")
+	var manage string
+	if m.IsValid() {
+		manage = fmt.Sprint(m.Interface())
+	}
+	switch {
+	case manage == "template" && !t.IsValid():
+		report.Error(m.line, "manage_etc_hosts is \"template\" but no etc_hosts_template is given")
+	case t.IsValid() && manage != "true" && manage != "template":
+		report.Warning(t.line, "etc_hosts_template is only used with manage_etc_hosts set to \"template\"")
+	}
+}
+
+// checkHostname verifies that the hostname and the fqdn follow RFC 1123, as
+// longer names are truncated, and that the FQDN can be preferred.
+func checkHostname(cfg node, report *Report) {
+	for _, k := range []string{"hostname", "fqdn"} {
+		c := cfg.Child(k)
+		if !c.IsValid() || fmt.Sprint(c.Interface()) == "" {
+			continue
+		}
+		name := strings.TrimSuffix(fmt.Sprint(c.Interface()), ".")
+		if len(name) > 253 {
+			report.Error(c.line, fmt.Sprintf("%s %q is longer than 253 characters", k, name))
+			continue
+		}
+		for _, label := range strings.Split(name, ".") {
+			if len(label) > 63 {
+				report.Error(c.line, fmt.Sprintf("label %q of %s %q is longer than 63 characters", label, k, name))
+			} else if !hostnameLabel.MatchString(label) {
+				report.Error(c.line, fmt.Sprintf("label %q of %s %q must only have letters, digits and inner hyphens", label, k, name))
+			}
+		}
+		if k == "fqdn" && !strings.Contains(name, ".") {
+			report.Warning(c.line, fmt.Sprintf("fqdn %q is not fully qualified", name))
+		}
+	}
+
+	p := cfg.Child("prefer_fqdn")
+	if !p.IsValid() || p.Kind() != reflect.Bool || !p.Bool() {
+		return
+	}
+	// Without fqdn, the FQDN may still come from the metadata.
+	fqdn := cfg.Child("fqdn")
+	if !fqdn.IsValid() {
+		fqdn = cfg.Child("hostname")
+	}
+	if fqdn.IsValid() && len(strings.TrimSuffix(fmt.Sprint(fqdn.Interface()), ".")) > 64 {
+		report.Warning(fqdn.line, "the FQDN is longer than 64 characters, the short hostname is used instead")
+	}
+}
+
+// checkCACerts verifies that the trusted CA certificates are PEM encoded
+// X.509 certificates and warns about the expired ones.
+func checkCACerts(cfg node, report *Report) {
+	for _, c := range cfg.Child("ca_certs").Child("trusted").children {
+		rest := []byte(fmt.Sprint(c.Interface()))
+		found := false
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			found = true
+			if block.Type != "CERTIFICATE" {
+				report.Error(c.line, fmt.Sprintf("unexpected PEM block %q, want a certificate", block.Type))
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				report.Error(c.line, fmt.Sprintf("invalid certificate: %v", err))
+				continue
+			}
+			if time.Now().After(cert.NotAfter) {
+				report.Warning(c.line, fmt.Sprintf("certificate %q expired on %s", cert.Subject, cert.NotAfter.Format(time.RFC3339)))
+			}
+		}
+		if !found {
+			report.Error(c.line, "certificate is not PEM encoded")
+		}
+	}
+}
+
+// checkSysctl verifies that the kernel parameters are of the form
+// "key=value" and, when run live, that the kernel has them. Parameters added
+// by modules which aren't loaded yet are unknown, hence the warning.
+func checkSysctl(cfg node, report *Report) {
+	c := cfg.Child("sysctl")
+	if !c.IsValid() {
+		return
+	}
+
+	var keys []node
+	switch c.Kind() {
+	case reflect.Map:
+		for _, p := range c.children {
+			switch p.Kind() {
+			case reflect.String, reflect.Int, reflect.Float64, reflect.Bool:
+				keys = append(keys, p)
+			default:
+				report.Error(p.line, fmt.Sprintf("invalid value for %q (want a single value)", p.name))
+			}
+		}
+	case reflect.Slice:
+		for _, p := range c.children {
+			key, _, ok := strings.Cut(fmt.Sprint(p.Interface()), "=")
+			if key = strings.TrimSpace(key); !ok || key == "" {
+				report.Error(p.line, fmt.Sprintf("invalid parameter %q (want \"key=value\")", p.Interface()))
+				continue
+			}
+			keys = append(keys, node{name: key, line: p.line})
+		}
+	default:
+		report.Error(c.line, "invalid sysctl (want a mapping or a list of \"key=value\")")
+		return
+	}
+
+	if _, err := os.Stat(sysctlRoot); err != nil {
+		return
+	}
+	for _, k := range keys {
+		// Globs and keys whose errors are ignored aren't checked.
+		if strings.HasPrefix(k.name, "-") || strings.Contains(k.name, "*") {
+			continue
+		}
+		if _, err := os.Stat(path.Join(sysctlRoot, system.SysctlPath(k.name))); err != nil {
+			report.Warning(k.line, fmt.Sprintf("unknown kernel parameter %q", k.name))
+		}
+	}
+}
+
+// checkKernelModules verifies that each kernel module has a name.
+func checkKernelModules(cfg node, report *Report) {
+	for _, m := range cfg.Child("kernel_modules").children {
+		if n := m.Child("name"); !n.IsValid() || fmt.Sprint(n.Interface()) == "" {
+			report.Error(m.line, "kernel module must have a name")
+		}
+	}
+}
@@ -15,8 +15,13 @@
 package validate
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
 	"regexp"
 	"strconv"
 	"strings"
@@ -40,7 +45,13 @@ func Validate(userdataBytes []byte) (Report, error) {
 		return Report{}, nil
 	case config.IsScript(string(userdataBytes)):
 		return Report{}, nil
+	case config.IsBoothook(string(userdataBytes)):
+		return Report{}, nil
 	case config.IsIgnitionConfig(string(userdataBytes)):
+		return validateIgnition(userdataBytes), nil
+	case config.IsInclude(string(userdataBytes)):
+		return Report{}, nil
+	case config.IsCloudConfigArchive(string(userdataBytes)):
 		return Report{}, nil
 	case config.IsCloudConfig(string(userdataBytes)):
 		return validateCloudConfig(userdataBytes, Rules)
@@ -53,6 +64,73 @@ func Validate(userdataBytes []byte) (Report, error) {
 	}
 }
 
+// ValidateSignatures reports every part of the given multipart MIME userdata
+// which is not signed by one of the trusted keys. Userdata that isn't
+// multipart MIME cannot carry a signature and is reported as a whole.
+func ValidateSignatures(userdataBytes []byte, keys config.TrustedKeys) Report {
+	report := Report{}
+	if len(userdataBytes) == 0 {
+		return report
+	}
+
+	unsigned := func(line int, name string, err error) {
+		report.Error(line, fmt.Sprintf("%s is not signed by a trusted key: %v", name, err))
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(string(userdataBytes)))
+	if err != nil || !config.IsMultipartMime(string(userdataBytes)) {
+		unsigned(1, "user-data", config.ErrUnsigned)
+		return report
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		unsigned(1, "user-data", config.ErrUnsigned)
+		return report
+	}
+
+	// Each part starts on the line following its boundary delimiter.
+	var starts []int
+	for i, line := range strings.Split(string(userdataBytes), "\n") {
+		if strings.TrimRight(line, " \t\r") == "--"+params["boundary"] {
+			starts = append(starts, i+2)
+		}
+	}
+
+	reader := multipart.NewReader(m.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		line := 1
+		if i < len(starts) {
+			line = starts[i]
+		}
+		if err != nil {
+			report.Error(line, fmt.Sprintf("malformed part: %v", err))
+			break
+		}
+
+		body, err := io.ReadAll(part)
+		if err == nil && part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body, err = base64.StdEncoding.DecodeString(string(body))
+		}
+		if err != nil {
+			report.Error(line, fmt.Sprintf("malformed part: %v", err))
+			continue
+		}
+
+		name := fmt.Sprintf("part %d", i+1)
+		if fileName := part.FileName(); fileName != "" {
+			name = fmt.Sprintf("part %q", fileName)
+		}
+		if err := keys.Verify(config.SignedData(part.Header, body), part.Header.Get(config.SignatureHeader)); err != nil {
+			unsigned(line, name, err)
+		}
+	}
+	return report
+}
+
 // validateCloudConfig runs all of the validation rules in Rules and returns
 // the resulting report and any errors encountered.
 func validateCloudConfig(config []byte, rules []rule) (report Report, err error) {
@@ -153,6 +231,10 @@ func normalizeNodeNames(node node, report *Report) node {
 		//report.Info(node.line, fmt.Sprintf("%q uses '-' instead of '_'", node.name))
 		node.name = strings.Replace(node.name, "-", "_", -1)
 	}
+	if node.name == "sysctl" {
+		// The keys of sysctl are kernel parameters, which are kept as is.
+		return node
+	}
 	for i := range node.children {
 		node.children[i] = normalizeNodeNames(node.children[i], report)
 	}
@@ -15,9 +15,14 @@
 package validate
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
+	"net/textproto"
 	"reflect"
 	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
 )
 
 func TestParseCloudConfig(t *testing.T) {
@@ -175,3 +180,73 @@ manage_etc_hosts: localhost`
 		}
 	}
 }
+
+func TestValidateSignatures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(contentType, body string) string {
+		data := config.SignedData(textproto.MIMEHeader{"Content-Type": {contentType}}, []byte(body))
+		return "ed25519=" + base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	}
+
+	tests := []struct {
+		userdata string
+
+		report Report
+	}{
+		{},
+		{
+			userdata: "#cloud-config\n",
+			report:   Report{entries: []Entry{{entryError, "user-data is not signed by a trusted key: no signature found", 1}}},
+		},
+		{
+			userdata: "Content-Type: multipart/mixed; boundary=\"BOUNDARY\"\n\n" +
+				"--BOUNDARY\nContent-Type: text/cloud-config\nX-Cloudinit-Signature: " + sign("text/cloud-config", "hostname: a") + "\n\nhostname: a\n" +
+				"--BOUNDARY\nContent-Type: text/x-shellscript\nContent-Disposition: attachment; filename=\"evil.sh\"\n\n#!/bin/sh\n" +
+				"--BOUNDARY\nContent-Type: text/x-shellscript\nContent-Transfer-Encoding: base64\nX-Cloudinit-Signature: " + sign("text/x-shellscript", "#!/bin/sh\n") + "\n\nIyEvYmluL3NoCg==\n" +
+				"--BOUNDARY\nContent-Type: text/cloud-config\nX-Cloudinit-Signature: " + sign("text/cloud-config", "hostname: a") + "\n\nhostname: b\n" +
+				"--BOUNDARY\nContent-Type: text/x-include-url\nX-Cloudinit-Signature: " + sign("text/cloud-config", "hostname: a") + "\n\nhostname: a\n" +
+				"--BOUNDARY--\n",
+			report: Report{entries: []Entry{
+				{entryError, `part "evil.sh" is not signed by a trusted key: no signature found`, 9},
+				{entryError, "part 4 is not signed by a trusted key: signature does not match any trusted key", 20},
+				{entryError, "part 5 is not signed by a trusted key: signature does not match any trusted key", 25},
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		r := ValidateSignatures([]byte(tt.userdata), config.TrustedKeys{pub})
+		if !reflect.DeepEqual(tt.report, r) {
+			t.Errorf("bad report (%d): want %#v, got %#v", i, tt.report, r)
+		}
+	}
+}
+
+func TestValidateIgnition(t *testing.T) {
+	config := `{
+  "ignition": {"version": "3.0.0"},
+  "storage": {
+    "disks": [],
+    "files": [
+      {"path": "/a", "contents": {"source": "data:,a"}},
+      {"path": "/b", "contents": {"source": "foo://b", "compression": "xz"}}
+    ]
+  }
+}`
+	want := Report{entries: []Entry{
+		{entryWarning, `unsupported Ignition key "storage.disks" is ignored`, 4},
+		{entryError, `unsupported source for "/b"`, 7},
+		{entryError, `unsupported compression "xz" for "/b"`, 7},
+	}}
+	r, err := Validate([]byte(config))
+	if err != nil {
+		t.Errorf("bad error: want nil, got %v", err)
+	}
+	if !reflect.DeepEqual(want, r) {
+		t.Errorf("bad report: want %+v, got %+v", want, r)
+	}
+}
+
@@ -17,6 +17,7 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -28,6 +29,7 @@ import (
 	"time"
 
 	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/config/convert"
 	"github.com/flatcar/coreos-cloudinit/config/validate"
 	"github.com/flatcar/coreos-cloudinit/datasource"
 	"github.com/flatcar/coreos-cloudinit/datasource/configdrive"
@@ -66,15 +68,31 @@ var (
 			cloudSigmaMetadataService   bool
 			digitalOceanMetadataService string
 			url                         string
+			urlChecksum                 string
 			procCmdLine                 bool
 			vmware                      bool
 			ovfEnv                      string
 		}
+		http struct {
+			proxy      string
+			caBundle   string
+			clientCert string
+			clientKey  string
+		}
 		convertNetconf string
 		workspace      string
 		sshKeyName     string
 		oem            string
 		validate       bool
+		ageKey         string
+		scripts        struct {
+			wait    bool
+			timeout time.Duration
+		}
+		signatures struct {
+			require     bool
+			trustedKeys string
+		}
 	}{}
 	version = "was not built properly"
 )
@@ -90,15 +108,25 @@ func init() {
 	flag.StringVar(&flags.sources.gceMetadataService, "from-gce-metadata", "", "Download GCE data from the provided url")
 	flag.BoolVar(&flags.sources.cloudSigmaMetadataService, "from-cloudsigma-metadata", false, "Download data from CloudSigma server context")
 	flag.StringVar(&flags.sources.digitalOceanMetadataService, "from-digitalocean-metadata", "", "Download DigitalOcean data from the provided url")
-	flag.StringVar(&flags.sources.url, "from-url", "", "Download user-data from provided url")
+	flag.StringVar(&flags.sources.url, "from-url", "", "Download user-data from provided url (http, https, data, file, tftp, s3 or gs)")
+	flag.StringVar(&flags.sources.urlChecksum, "url-checksum", "", "Expected digest (sha256=<hex> or sha512=<hex>) of the user-data downloaded with --from-url")
 	flag.BoolVar(&flags.sources.procCmdLine, "from-proc-cmdline", false, fmt.Sprintf("Parse %s for '%s=<url>', using the cloud-config served by an HTTP GET to <url>", proc_cmdline.ProcCmdlineLocation, proc_cmdline.ProcCmdlineCloudConfigFlag))
 	flag.BoolVar(&flags.sources.vmware, "from-vmware-guestinfo", false, "Read data from VMware guestinfo")
 	flag.StringVar(&flags.sources.ovfEnv, "from-vmware-ovf-env", "", "Read data from OVF Environment")
+	flag.StringVar(&flags.http.proxy, "http-proxy", "", "Proxy to use for HTTP(S) requests, overriding HTTPS_PROXY and HTTP_PROXY")
+	flag.StringVar(&flags.http.caBundle, "http-ca-bundle", "", "Trust the certificate authorities in the provided PEM file for HTTPS requests")
+	flag.StringVar(&flags.http.clientCert, "http-client-cert", "", "Present the provided PEM certificate to HTTPS servers requesting client authentication")
+	flag.StringVar(&flags.http.clientKey, "http-client-key", "", "Private key for the certificate provided with --http-client-cert")
 	flag.StringVar(&flags.oem, "oem", "", "Use the settings specific to the provided OEM")
 	flag.StringVar(&flags.convertNetconf, "convert-netconf", "", "Read the network config provided in cloud-drive and translate it from the specified format into networkd unit files")
 	flag.StringVar(&flags.workspace, "workspace", "/var/lib/coreos-cloudinit", "Base directory coreos-cloudinit should use to store data")
 	flag.StringVar(&flags.sshKeyName, "ssh-key-name", initialize.DefaultSSHKeyName, "Add SSH keys to the system with the given name")
 	flag.BoolVar(&flags.validate, "validate", false, "[EXPERIMENTAL] Validate the user-data but do not apply it to the system")
+	flag.BoolVar(&flags.scripts.wait, "wait-for-scripts", false, "Run user-data scripts one after the other, waiting for each to exit, and fail if any of them fails")
+	flag.DurationVar(&flags.scripts.timeout, "script-timeout", initialize.DefaultScriptTimeout, "Kill bootcmd and runcmd commands, and user-data scripts with --wait-for-scripts, running for longer than this (0 to disable)")
+	flag.StringVar(&flags.ageKey, "age-key", "", fmt.Sprintf("Decrypt age encrypted user-data with the identities in the provided file instead of %s", strings.Join(config.AgeKeyFiles, " or ")))
+	flag.BoolVar(&flags.signatures.require, "require-signatures", false, "Reject user-data parts which are not signed by one of the trusted keys")
+	flag.StringVar(&flags.signatures.trustedKeys, "trusted-keys-dir", config.DefaultTrustedKeysDir, "Directory containing the public keys trusted to sign user-data")
 }
 
 type oemConfig map[string]string
@@ -137,6 +165,13 @@ func main() {
 		runtime.GOMAXPROCS(1)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(convertUserdata(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "finish-script-run" {
+		os.Exit(finishScriptRun(os.Args[2:]))
+	}
+
 	flag.Parse()
 
 	if c, ok := oemConfigs[flags.oem]; ok {
@@ -166,6 +201,39 @@ func main() {
 		os.Exit(2)
 	}
 
+	if err := pkg.SetDefaultHttpClientOptions(pkg.HttpClientOptions{
+		Proxy:      flags.http.proxy,
+		CABundle:   flags.http.caBundle,
+		ClientCert: flags.http.clientCert,
+		ClientKey:  flags.http.clientKey,
+	}); err != nil {
+		fmt.Printf("Invalid HTTP client configuration: %v\n", err)
+		os.Exit(2)
+	}
+
+	if flags.sources.urlChecksum != "" {
+		if _, err := pkg.ParseChecksum(flags.sources.urlChecksum); err != nil {
+			fmt.Printf("Invalid option to -url-checksum: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if flags.ageKey != "" {
+		config.AgeKeyFiles = []string{flags.ageKey}
+	}
+
+	var trustedKeys config.TrustedKeys
+	if flags.signatures.require {
+		var err error
+		if trustedKeys, err = config.LoadTrustedKeys(flags.signatures.trustedKeys); err != nil {
+			fmt.Printf("Failed to load trusted keys: %v\n", err)
+			os.Exit(1)
+		} else if len(trustedKeys) == 0 {
+			fmt.Printf("No trusted keys found in %s\n", flags.signatures.trustedKeys)
+			os.Exit(1)
+		}
+	}
+
 	dss := getDatasources()
 	if len(dss) == 0 {
 		fmt.Println("Provide at least one of --from-file, --from-configdrive, --from-ec2-metadata, --from-gce-metadata, --from-cloudsigma-metadata, --from-digitalocean-metadata, --from-vmware-guestinfo, --from-waagent, --from-url or --from-proc-cmdline")
@@ -185,18 +253,20 @@ func main() {
 		os.Exit(1)
 	}
 	env := initialize.NewEnvironment("/", ds.ConfigRoot(), flags.workspace, flags.sshKeyName, metadata)
-
-	// Setup networking units
-	if flags.convertNetconf != "" {
-		if err := setupNetworkUnits(metadata.NetworkConfig, env, flags.convertNetconf); err != nil {
-			log.Printf("Failed to setup network units: %v\n", err)
-			os.Exit(1)
-		}
+	if flags.signatures.require {
+		env.RequireSignatures(trustedKeys)
+	}
+	env.SetScriptTimeout(flags.scripts.timeout)
+	if flags.scripts.wait {
+		env.WaitForScripts()
 	}
 
 	log.Printf("Fetching user-data from datasource of type %q\n", ds.Type())
 	userdataBytes, err := ds.FetchUserdata()
-	if err != nil {
+	if errors.As(err, &pkg.ErrChecksum{}) {
+		log.Printf("Refusing to use user-data from datasource: %v\n", err)
+		os.Exit(1)
+	} else if err != nil {
 		log.Printf("Failed fetching user-data from datasource: %v. Continuing...\n", err)
 		failure = true
 	}
@@ -208,7 +278,12 @@ func main() {
 
 	if report, err := validate.Validate(userdataBytes); err == nil {
 		ret := 0
-		for _, e := range report.Entries() {
+		entries := report.Entries()
+		if flags.signatures.require {
+			sigReport := validate.ValidateSignatures(userdataBytes, trustedKeys)
+			entries = append(entries, sigReport.Entries()...)
+		}
+		for _, e := range entries {
 			log.Println(e)
 			ret = 1
 		}
@@ -226,14 +301,42 @@ func main() {
 	if err != nil {
 		log.Printf("Failed to parse user-data: %v\nContinuing...\n", err)
 		failure = true
+	} else if len(udata.Rejected) > 0 {
+		log.Printf("Rejected %d user-data parts without a valid signature\n", len(udata.Rejected))
+		failure = true
+	} else if err := udata.MergeCloudConfigs(); err != nil {
+		log.Printf("Failed to merge cloud-configs: %v\nContinuing...\n", err)
+		failure = true
+	}
+
+	// Boothooks and bootcmd run before anything else, including the network
+	// units.
+	if !failure && udata != nil {
+		if err := udata.RunBoothooks(env); err != nil {
+			log.Printf("Failed to run boothooks: %v", err)
+			failure = true
+		} else if err := udata.RunBootcmd(env); err != nil {
+			log.Printf("Failed to run bootcmd: %v", err)
+			failure = true
+		}
+	}
+
+	// Setup networking units
+	if flags.convertNetconf != "" {
+		if err := setupNetworkUnits(metadata.NetworkConfig, env, flags.convertNetconf); err != nil {
+			log.Printf("Failed to setup network units: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	mustStop := false
 	hostname := determineHostname(metadata, udata)
-	if err := initialize.ApplyHostname(hostname); err != nil {
+	fqdn := determineFQDN(metadata, udata)
+	if err := initialize.ApplyHostname(hostname, fqdn); err != nil {
 		log.Printf("Failed to set hostname: %v", err)
 		mustStop = true
 	}
+	env.SetHostname(hostname, fqdn)
 
 	mergedKeys := mergeSSHKeysFromSources(metadata, udata)
 	if err := initialize.ApplyCoreUserSSHKeys(mergedKeys, env); err != nil {
@@ -257,6 +360,10 @@ func main() {
 				failure = true
 			}
 		}
+		if err := udata.RunRuncmd(env); err != nil {
+			log.Printf("Failed to run runcmd: %v", err)
+			failure = true
+		}
 	}
 
 	if failure && !flags.ignoreFailure {
@@ -264,16 +371,25 @@ func main() {
 	}
 }
 
+// maxHostnameLength is the longest hostname the kernel accepts.
+const maxHostnameLength = 64
+
 // determineHostname returns either the hostname from the metadata, or the hostname from the
 // supplied cloud-config. The cloud-config hostname takes precedence, and we stop after the first
-// cloud-config that gives us a hostname.
+// cloud-config that gives us a hostname. When a cloud-config prefers the FQDN, the FQDN is
+// returned as long as the kernel accepts it.
 func determineHostname(md datasource.Metadata, udata *initialize.UserData) string {
-	hostname := md.Hostname
-	if udata != nil {
-		udataHostname := udata.FindHostname()
-		if udataHostname != "" {
-			hostname = udataHostname
+	fqdn := determineFQDN(md, udata)
+	if udata != nil && udata.FindPreferFQDN() && fqdn != "" {
+		if len(fqdn) <= maxHostnameLength {
+			return fqdn
 		}
+		log.Printf("FQDN %s is longer than %d bytes, using the short hostname instead", fqdn, maxHostnameLength)
+	}
+
+	hostname := findHostname(md, udata)
+	if hostname == "" {
+		hostname = fqdn
 	}
 	// Always truncate hostnames to everything before the first `.`
 	hostname = strings.Split(hostname, ".")[0]
@@ -287,6 +403,35 @@ func determineHostname(md datasource.Metadata, udata *initialize.UserData) strin
 	return hostname
 }
 
+// determineFQDN returns the fqdn of the supplied cloud-config or else the fully-qualified domain
+// name given as hostname by the metadata or the supplied cloud-config, with the same precedence
+// as determineHostname. It returns an empty string if there is no qualified name.
+func determineFQDN(md datasource.Metadata, udata *initialize.UserData) string {
+	var fqdn string
+	if udata != nil {
+		fqdn = udata.FindFQDN()
+	}
+	if fqdn == "" {
+		fqdn = findHostname(md, udata)
+	}
+	fqdn = strings.TrimSuffix(fqdn, ".")
+	if !strings.Contains(fqdn, ".") {
+		return ""
+	}
+	return fqdn
+}
+
+func findHostname(md datasource.Metadata, udata *initialize.UserData) string {
+	hostname := md.Hostname
+	if udata != nil {
+		udataHostname := udata.FindHostname()
+		if udataHostname != "" {
+			hostname = udataHostname
+		}
+	}
+	return hostname
+}
+
 // mergeSSHKeysFromSources creates a list of all SSH keys from meta-data and the supplied
 // cloud-config sources.
 func mergeSSHKeysFromSources(md datasource.Metadata, udata *initialize.UserData) []string {
@@ -331,7 +476,7 @@ func getDatasources() []datasource.Datasource {
 		dss = append(dss, file.NewDatasource(flags.sources.file))
 	}
 	if flags.sources.url != "" {
-		dss = append(dss, url.NewDatasource(flags.sources.url))
+		dss = append(dss, url.NewDatasource(flags.sources.url, flags.sources.urlChecksum))
 	}
 	if flags.sources.configDrive != "" {
 		dss = append(dss, configdrive.NewDatasource(flags.sources.configDrive))
@@ -427,7 +572,7 @@ func runScript(script config.Script, env *initialize.Environment) error {
 	path, err := initialize.PersistScriptInWorkspace(script, env.Workspace())
 	if err == nil {
 		var name string
-		name, err = system.ExecuteScript(path)
+		name, err = system.ExecuteScript(path, config.ScriptOptions{}, nil)
 		initialize.PersistUnitNameInWorkspace(name, env.Workspace())
 	}
 	return err
@@ -435,6 +580,61 @@ func runScript(script config.Script, env *initialize.Environment) error {
 
 const gzipMagicBytes = "\x1f\x8b"
 
+// finishScriptRun implements the finish-script-run subcommand, which the
+// unit of a user-data script that isn't waited for runs once the script has
+// exited, to record the end of the run in the given directory of the
+// workspace. It returns the exit status.
+func finishScriptRun(args []string) int {
+	if len(args) != 1 {
+		log.Printf("Usage: coreos-cloudinit finish-script-run <run directory>\n")
+		return 2
+	}
+	if err := initialize.FinishScriptRun(args[0], os.Getenv("SERVICE_RESULT"), os.Getenv("EXIT_CODE"), os.Getenv("EXIT_STATUS")); err != nil {
+		log.Printf("Failed recording the end of the script run: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// convertUserdata implements the convert subcommand, which prints the
+// cloud-config read from a file or stdin translated into an Ignition or
+// Butane config, and returns the exit status.
+func convertUserdata(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	to := fs.String("to", convert.FormatIgnition, fmt.Sprintf("Format of the output (%s or %s)", convert.FormatIgnition, convert.FormatButane))
+	from := fs.String("from-file", "", "Read the cloud-config from the provided file instead of stdin")
+	fs.Parse(args)
+
+	var userdataBytes []byte
+	var err error
+	if *from != "" {
+		userdataBytes, err = ioutil.ReadFile(*from)
+	} else {
+		userdataBytes, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err == nil {
+		userdataBytes, err = decompressIfGzip(userdataBytes)
+	}
+	if err != nil {
+		log.Printf("Failed reading user-data: %v\n", err)
+		return 1
+	}
+
+	out, report, err := convert.Convert(userdataBytes, *to)
+	if err != nil {
+		log.Printf("Failed converting user-data: %v\n", err)
+		return 1
+	}
+	for _, e := range report.Entries() {
+		log.Println(e)
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		log.Printf("Failed writing %s config: %v\n", *to, err)
+		return 1
+	}
+	return 0
+}
+
 func decompressIfGzip(userdataBytes []byte) ([]byte, error) {
 	if !bytes.HasPrefix(userdataBytes, []byte(gzipMagicBytes)) {
 		return userdataBytes, nil
@@ -170,3 +170,72 @@ func TestDetermineHostname(t *testing.T) {
 
 	}
 }
+
+func TestDetermineFQDN(t *testing.T) {
+	for _, tt := range []struct {
+		metaData datasource.Metadata
+		uData    string
+		hostname string
+		fqdn     string
+	}{
+		{
+			metaData: datasource.Metadata{},
+			hostname: "",
+			fqdn:     "",
+		},
+		{
+			metaData: datasource.Metadata{Hostname: "regular-name"},
+			hostname: "regular-name",
+			fqdn:     "",
+		},
+		{
+			metaData: datasource.Metadata{Hostname: "regular-name.domain"},
+			hostname: "regular-name",
+			fqdn:     "regular-name.domain",
+		},
+		{
+			metaData: datasource.Metadata{Hostname: "regular-name.domain."},
+			hostname: "regular-name",
+			fqdn:     "regular-name.domain",
+		},
+		{
+			metaData: datasource.Metadata{Hostname: "regular-name"},
+			uData:    "#cloud-config\nfqdn: host.example.com",
+			hostname: "regular-name",
+			fqdn:     "host.example.com",
+		},
+		{
+			metaData: datasource.Metadata{},
+			uData:    "#cloud-config\nfqdn: host.example.com",
+			hostname: "host",
+			fqdn:     "host.example.com",
+		},
+		{
+			metaData: datasource.Metadata{Hostname: "regular-name"},
+			uData:    "#cloud-config\nfqdn: host.example.com\nprefer_fqdn: true",
+			hostname: "host.example.com",
+			fqdn:     "host.example.com",
+		},
+		{
+			metaData: datasource.Metadata{},
+			uData:    "#cloud-config\nfqdn: host.this-domain-name-is-long-enough-to-make-the-fqdn-longer-than.example.com\nprefer_fqdn: true",
+			hostname: "host",
+			fqdn:     "host.this-domain-name-is-long-enough-to-make-the-fqdn-longer-than.example.com",
+		},
+	} {
+		var udata *initialize.UserData
+		if tt.uData != "" {
+			var err error
+			env := initialize.NewEnvironment("/", "", "", "", tt.metaData)
+			if udata, err = initialize.NewUserData(tt.uData, env); err != nil {
+				t.Fatalf("Bad user-data %q: %v", tt.uData, err)
+			}
+		}
+		if hostname := determineHostname(tt.metaData, udata); tt.hostname != hostname {
+			t.Errorf("Bad hostname (%q), want %s, got %s", tt.uData, tt.hostname, hostname)
+		}
+		if fqdn := determineFQDN(tt.metaData, udata); tt.fqdn != fqdn {
+			t.Errorf("Bad FQDN (%q), want %s, got %s", tt.uData, tt.fqdn, fqdn)
+		}
+	}
+}
@@ -53,6 +53,7 @@ func (cd *configDrive) ConfigRoot() string {
 func (cd *configDrive) FetchMetadata() (metadata datasource.Metadata, err error) {
 	var data []byte
 	var m struct {
+		UUID                string            `json:"uuid"`
 		SSHAuthorizedKeyMap map[string]string `json:"public_keys"`
 		Hostname            string            `json:"hostname"`
 		NetworkConfig       struct {
@@ -67,6 +68,7 @@ func (cd *configDrive) FetchMetadata() (metadata datasource.Metadata, err error)
 		return
 	}
 
+	metadata.InstanceID = m.UUID
 	metadata.SSHPublicKeys = m.SSHAuthorizedKeyMap
 	metadata.Hostname = m.Hostname
 	if m.NetworkConfig.ContentPath != "" {
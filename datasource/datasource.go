@@ -28,6 +28,7 @@ type Datasource interface {
 }
 
 type Metadata struct {
+	InstanceID    string
 	PublicIPv4    net.IP
 	PublicIPv6    net.IP
 	PrivateIPv4   net.IP
@@ -55,6 +55,7 @@ type DNS struct {
 }
 
 type Metadata struct {
+	DropletID  int        `json:"droplet_id"`
 	Hostname   string     `json:"hostname"`
 	Interfaces Interfaces `json:"interfaces"`
 	PublicKeys []string   `json:"public_keys"`
@@ -96,6 +97,9 @@ func (ms *metadataService) FetchMetadata() (metadata datasource.Metadata, err er
 			metadata.PrivateIPv6 = net.ParseIP(m.Interfaces.Private[0].IPv6.IPAddress)
 		}
 	}
+	if m.DropletID != 0 {
+		metadata.InstanceID = strconv.Itoa(m.DropletID)
+	}
 	metadata.Hostname = m.Hostname
 	metadata.SSHPublicKeys = map[string]string{}
 	for i, key := range m.PublicKeys {
@@ -77,6 +77,12 @@ func (ms metadataService) FetchMetadata() (datasource.Metadata, error) {
 		return metadata, err
 	}
 
+	if instanceID, err := ms.fetchAttribute(fmt.Sprintf("%s/instance-id", ms.MetadataUrl())); err == nil {
+		metadata.InstanceID = instanceID
+	} else if _, ok := err.(pkg.ErrNotFound); !ok {
+		return metadata, err
+	}
+
 	if hostname, err := ms.fetchAttribute(fmt.Sprintf("%s/hostname", ms.MetadataUrl())); err == nil {
 		metadata.Hostname = hostname
 	} else if _, ok := err.(pkg.ErrNotFound); !ok {
@@ -50,8 +50,13 @@ func (ms metadataService) FetchMetadata() (datasource.Metadata, error) {
 	if err != nil {
 		return datasource.Metadata{}, err
 	}
+	instanceID, err := ms.fetchString("id")
+	if err != nil {
+		return datasource.Metadata{}, err
+	}
 
 	return datasource.Metadata{
+		InstanceID:  instanceID,
 		PublicIPv4:  public,
 		PrivateIPv4: local,
 		Hostname:    hostname,
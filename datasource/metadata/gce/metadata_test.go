@@ -62,10 +62,12 @@ func TestFetchMetadata(t *testing.T) {
 			metadataPath: "computeMetadata/v1/instance/",
 			resources: map[string]string{
 				"/computeMetadata/v1/instance/hostname":                                          "host",
+				"/computeMetadata/v1/instance/id":                                                "4567",
 				"/computeMetadata/v1/instance/network-interfaces/0/ip":                           "1.2.3.4",
 				"/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip": "5.6.7.8",
 			},
 			expect: datasource.Metadata{
+				InstanceID:  "4567",
 				Hostname:    "host",
 				PrivateIPv4: net.ParseIP("1.2.3.4"),
 				PublicIPv4:  net.ParseIP("5.6.7.8"),
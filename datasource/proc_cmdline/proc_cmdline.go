@@ -27,6 +27,11 @@ import (
 const (
 	ProcCmdlineLocation        = "/proc/cmdline"
 	ProcCmdlineCloudConfigFlag = "cloud-config-url"
+
+	ProcCmdlineProxyFlag      = "cloud-config-proxy"
+	ProcCmdlineCABundleFlag   = "cloud-config-ca-bundle"
+	ProcCmdlineClientCertFlag = "cloud-config-client-cert"
+	ProcCmdlineClientKeyFlag  = "cloud-config-client-key"
 )
 
 type procCmdline struct {
@@ -72,8 +77,11 @@ func (c *procCmdline) FetchUserdata() ([]byte, error) {
 		return nil, err
 	}
 
-	client := pkg.NewHttpClient()
-	cfg, err := client.GetRetry(url)
+	client, err := pkg.NewHttpClientOptions(nil, findHttpClientOptions(cmdline, pkg.DefaultHttpClientOptions()))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := client.Fetch(url)
 	if err != nil {
 		return nil, err
 	}
@@ -108,3 +116,28 @@ func findCloudConfigURL(input string) (url string, err error) {
 
 	return
 }
+
+// findHttpClientOptions overrides the given options with any HTTP client
+// settings found on the kernel command line.
+func findHttpClientOptions(input string, opts pkg.HttpClientOptions) pkg.HttpClientOptions {
+	for _, token := range strings.Split(input, " ") {
+		parts := strings.SplitN(token, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+
+		key := strings.Replace(parts[0], "_", "-", -1)
+		switch key {
+		case ProcCmdlineProxyFlag:
+			opts.Proxy = parts[1]
+		case ProcCmdlineCABundleFlag:
+			opts.CABundle = parts[1]
+		case ProcCmdlineClientCertFlag:
+			opts.ClientCert = parts[1]
+		case ProcCmdlineClientKeyFlag:
+			opts.ClientKey = parts[1]
+		}
+	}
+
+	return opts
+}
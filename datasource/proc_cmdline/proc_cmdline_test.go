@@ -21,6 +21,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/pkg"
 )
 
 func TestParseCmdlineCloudConfigFound(t *testing.T) {
@@ -100,3 +102,43 @@ func TestProcCmdlineAndFetchConfig(t *testing.T) {
 		t.Errorf("Test failed, response body: %s != %s", cfg, CloudConfigContent)
 	}
 }
+
+func TestParseCmdlineHttpClientOptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		defaults pkg.HttpClientOptions
+		expect   pkg.HttpClientOptions
+	}{
+		{
+			"cloud-config-url=example.com",
+			pkg.HttpClientOptions{},
+			pkg.HttpClientOptions{},
+		},
+		{
+			"cloud-config-url=example.com",
+			pkg.HttpClientOptions{Proxy: "http://proxy:3128"},
+			pkg.HttpClientOptions{Proxy: "http://proxy:3128"},
+		},
+		{
+			"cloud-config-url=example.com cloud_config_proxy=http://other:8080",
+			pkg.HttpClientOptions{Proxy: "http://proxy:3128"},
+			pkg.HttpClientOptions{Proxy: "http://other:8080"},
+		},
+		{
+			"cloud-config-ca-bundle=/oem/ca.pem cloud-config-client-cert=/oem/cert.pem cloud-config-client-key=/oem/key.pem",
+			pkg.HttpClientOptions{},
+			pkg.HttpClientOptions{CABundle: "/oem/ca.pem", ClientCert: "/oem/cert.pem", ClientKey: "/oem/key.pem"},
+		},
+		{
+			"cloud-config-proxy= cloud-config-ca-bundle",
+			pkg.HttpClientOptions{Proxy: "http://proxy:3128"},
+			pkg.HttpClientOptions{Proxy: "http://proxy:3128"},
+		},
+	}
+
+	for i, tt := range tests {
+		if output := findHttpClientOptions(tt.input, tt.defaults); output != tt.expect {
+			t.Errorf("Test case %d failed: %+v != %+v", i, output, tt.expect)
+		}
+	}
+}
@@ -15,20 +15,34 @@
 package url
 
 import (
+	neturl "net/url"
+	"strings"
+
 	"github.com/flatcar/coreos-cloudinit/datasource"
 	"github.com/flatcar/coreos-cloudinit/pkg"
 )
 
 type remoteFile struct {
-	url string
+	url      string
+	checksum string
 }
 
-func NewDatasource(url string) *remoteFile {
-	return &remoteFile{url}
+// NewDatasource creates a datasource for the user-data at url. If checksum is
+// not empty, the fetched user-data must match it (see pkg.ParseChecksum).
+func NewDatasource(url, checksum string) *remoteFile {
+	return &remoteFile{url, checksum}
 }
 
 func (f *remoteFile) IsAvailable() bool {
 	client := pkg.NewHttpClient()
+	if u, err := neturl.Parse(f.url); err == nil && !strings.HasPrefix(u.Scheme, "http") {
+		// A digest that doesn't match must abort the run when the
+		// user-data is fetched rather than make the datasource look
+		// unavailable.
+		u.Fragment = ""
+		_, err := client.Fetch(u.String())
+		return (err == nil)
+	}
 	_, err := client.Get(f.url)
 	return (err == nil)
 }
@@ -47,7 +61,19 @@ func (f *remoteFile) FetchMetadata() (datasource.Metadata, error) {
 
 func (f *remoteFile) FetchUserdata() ([]byte, error) {
 	client := pkg.NewHttpClient()
-	return client.GetRetry(f.url)
+	data, err := client.Fetch(f.url)
+	if err != nil || f.checksum == "" {
+		return data, err
+	}
+
+	checksum, err := pkg.ParseChecksum(f.checksum)
+	if err != nil {
+		return nil, err
+	}
+	if err := checksum.Verify(data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 func (f *remoteFile) Type() string {
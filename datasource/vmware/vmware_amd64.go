@@ -102,5 +102,5 @@ func getOvfReadConfig(ovfEnv []byte) readConfigFunction {
 
 func urlDownload(url string) ([]byte, error) {
 	client := pkg.NewHttpClient()
-	return client.GetRetry(url)
+	return client.Fetch(url)
 }
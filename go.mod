@@ -3,9 +3,11 @@ module github.com/flatcar/coreos-cloudinit
 go 1.20
 
 require (
+	filippo.io/age v1.2.1
 	github.com/cloudsigma/cepgo v0.0.0-20140805094338-1bfc4895bf5c
 	github.com/coreos/go-systemd v0.0.0-20140326023052-4fbc5060a317
 	github.com/dotcloud/docker v0.11.2-0.20140522020950-55d41c3e21e1
+	github.com/guelfey/go.dbus v0.0.0-20131113121618-f6a3a2366cc3
 	github.com/sigma/vmw-ovflib v0.0.0-20150531125353-56b4f44581ca
 	github.com/stretchr/testify v1.8.2
 	github.com/vmware/vmw-guestinfo v0.0.0-20170622145319-ab8497750719
@@ -14,10 +16,11 @@ require (
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/guelfey/go.dbus v0.0.0-20131113121618-f6a3a2366cc3 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/smartystreets/goconvey v1.7.2 // indirect
 	github.com/tarm/goserial v0.0.0-20140420040555-cdabc8d44e8e // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
 
@@ -0,0 +1,74 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/system"
+)
+
+// RunBoothooks synchronously runs the boothook parts of the user-data, each
+// at most once per instance. It is meant to be called before anything else
+// is applied, including the network configuration of the datasource.
+func (ud *UserData) RunBoothooks(env *Environment) error {
+	for _, part := range ud.Parts {
+		if !part.IsBoothook() {
+			continue
+		}
+		if err := part.runBoothook(env); err != nil {
+			return fmt.Errorf("error running boothook %q: %w", part.PartName(), err)
+		}
+	}
+	return nil
+}
+
+func (udp *UserDataPart) runBoothook(env *Environment) error {
+	if env == nil {
+		return fmt.Errorf("environment is nil")
+	}
+
+	// The guard is named after the script so that changed boothooks run
+	// again, even within the same instance.
+	guard := path.Join("boothooks", env.instanceKey(), fmt.Sprintf("%x", sha256.Sum256(*udp.script)))
+	if _, err := os.Stat(path.Join(env.Workspace(), guard)); err == nil {
+		log.Printf("Boothook %q has already run on this instance", udp.PartName())
+		return nil
+	}
+
+	if err := PrepWorkspace(env.Workspace()); err != nil {
+		return err
+	}
+	scriptPath, err := PersistScriptInWorkspace(*udp.script, env.Workspace())
+	if err != nil {
+		return err
+	}
+	if err := system.RunScript(scriptPath, []string{"INSTANCE_ID=" + env.InstanceID()}); err != nil {
+		return err
+	}
+
+	file := system.File{File: config.File{
+		Path:               guard,
+		RawFilePermissions: "0644",
+		Content:            udp.PartName() + "\n",
+	}}
+	_, err = system.WriteFile(&file, env.Workspace())
+	return err
+}
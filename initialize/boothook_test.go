@@ -0,0 +1,49 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/datasource"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBoothooks(t *testing.T) {
+	root := t.TempDir()
+	out := path.Join(root, "out")
+	payload := signedMultipart(t, nil, []testPart{
+		{"early.sh", "text/cloud-boothook", "#!/bin/bash\necho \"sh $INSTANCE_ID\" >> " + out + "\n", false},
+		{"hook", "text/plain", "#cloud-boothook\necho \"no shebang $INSTANCE_ID\" >> " + out + "\n", false},
+		{"late.sh", "text/x-shellscript", "#!/bin/bash\necho late >> " + out + "\n", false},
+	})
+
+	for _, id := range []string{"i-1", "i-1", "i-2"} {
+		env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: id})
+		udata, err := NewUserData(payload, env)
+		require.NoError(t, err)
+		require.Equal(t, 3, len(udata.Parts))
+		require.True(t, udata.Parts[0].IsBoothook())
+		require.True(t, udata.Parts[1].IsBoothook())
+		require.NoError(t, udata.RunBoothooks(env))
+	}
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "sh i-1\nno shebang i-1\nsh i-2\nno shebang i-2\n", string(data))
+}
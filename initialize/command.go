@@ -0,0 +1,113 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/system"
+)
+
+// executeCommand runs a command in a transient unit and waits for it to
+// exit. It is a variable so that tests can replace it.
+var executeCommand = system.ExecuteCommandAndWait
+
+// RunBootcmd runs the bootcmd commands of the cloud-configs, one after the
+// other, on every boot. It is meant to be called right after the boothooks.
+func (ud *UserData) RunBootcmd(env *Environment) error {
+	return ud.runCommands("bootcmd", ud.commands(func(cc *config.CloudConfig) []config.Command { return cc.Bootcmd }), env)
+}
+
+// RunRuncmd runs the runcmd commands of the cloud-configs, one after the
+// other, once per instance. It is meant to be called once all the parts have
+// been run. If a command fails, they all run again on the next boot.
+func (ud *UserData) RunRuncmd(env *Environment) error {
+	commands := ud.commands(func(cc *config.CloudConfig) []config.Command { return cc.Runcmd })
+	if len(commands) == 0 {
+		return nil
+	}
+
+	// The guard is named after the commands so that changed commands run
+	// again, even within the same instance.
+	encoded, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+	guard := path.Join("runcmd", env.instanceKey(), fmt.Sprintf("%x", sha256.Sum256(encoded)))
+	if _, err := os.Stat(path.Join(env.Workspace(), guard)); err == nil {
+		log.Printf("runcmd has already run on this instance")
+		return nil
+	}
+
+	if err := ud.runCommands("runcmd", commands, env); err != nil {
+		return err
+	}
+
+	file := system.File{File: config.File{
+		Path:               guard,
+		RawFilePermissions: "0644",
+		Content:            string(encoded) + "\n",
+	}}
+	_, err = system.WriteFile(&file, env.Workspace())
+	return err
+}
+
+func (ud *UserData) commands(section func(*config.CloudConfig) []config.Command) []config.Command {
+	var commands []config.Command
+	for _, part := range ud.Parts {
+		if part.cloudConfig != nil {
+			commands = append(commands, section(part.cloudConfig)...)
+		}
+	}
+	return commands
+}
+
+// runCommands runs the commands of a section in order, stopping at the first
+// one which fails. Each run is recorded in the workspace as the part
+// <section>-<index>.
+func (ud *UserData) runCommands(section string, commands []config.Command, env *Environment) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	if err := PrepWorkspace(env.Workspace()); err != nil {
+		return err
+	}
+
+	opts := ud.scriptDefaults
+	opts.Interpreter = ""
+	// Commands are always waited for, so they always get a timeout lest a
+	// hung one blocks the boot.
+	opts = env.scriptOptions(opts, true)
+	for i, command := range commands {
+		log.Printf("Running %s command %d: %q", section, i, []string(command))
+		run := ScriptRun{Start: time.Now()}
+		var status int32
+		var err error
+		run.Unit, status, err = executeCommand(section, command, opts)
+		run.finish(status)
+		persistScriptRun(fmt.Sprintf("%s-%d", section, i), run, err, env)
+		if err != nil {
+			return fmt.Errorf("%s command %d failed: %w", section, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/datasource"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommands(t *testing.T) {
+	var ran []string
+	fail := ""
+	orig := executeCommand
+	defer func() { executeCommand = orig }()
+	executeCommand = func(prefix string, command []string, opts config.ScriptOptions) (string, int32, error) {
+		ran = append(ran, prefix+": "+command[len(command)-1])
+		require.Contains(t, opts.Environment, "INSTANCE_ID=i-1")
+		require.Contains(t, opts.Environment, "A=1")
+		require.Equal(t, "", opts.Interpreter)
+		require.Equal(t, "10m0s", opts.Timeout)
+		if command[len(command)-1] == fail {
+			return "", 1, errors.New("failed")
+		}
+		return "", 0, nil
+	}
+
+	root := t.TempDir()
+	payload := `#cloud-config
+scripts:
+  interpreter: /usr/bin/python3
+  environment: [A=1]
+bootcmd:
+  - echo boot
+runcmd:
+  - echo run
+  - [ls, /]
+`
+	for _, tt := range []struct {
+		fail string
+		ran  []string
+		err  bool
+	}{
+		{"/", []string{"bootcmd: echo boot", "runcmd: echo run", "runcmd: /"}, true},
+		// Failed commands run again.
+		{"", []string{"bootcmd: echo boot", "runcmd: echo run", "runcmd: /"}, false},
+		// runcmd only runs once per instance.
+		{"", []string{"bootcmd: echo boot"}, false},
+	} {
+		ran, fail = nil, tt.fail
+		env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+		udata, err := NewUserData(payload, env)
+		require.NoError(t, err)
+		require.NoError(t, udata.RunBootcmd(env))
+		if tt.err {
+			require.Error(t, udata.RunRuncmd(env))
+		} else {
+			require.NoError(t, udata.RunRuncmd(env))
+		}
+		require.Equal(t, tt.ran, ran)
+	}
+
+	for _, part := range []string{"bootcmd-0", "runcmd-0", "runcmd-1"} {
+		_, err := os.Stat(path.Join(root, "var/lib/coreos-cloudinit/scripts", part))
+		require.NoError(t, err)
+	}
+}
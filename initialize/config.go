@@ -18,9 +18,11 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"strings"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 	"github.com/flatcar/coreos-cloudinit/network"
+	"github.com/flatcar/coreos-cloudinit/pkg"
 	"github.com/flatcar/coreos-cloudinit/system"
 )
 
@@ -38,11 +40,17 @@ type CloudConfigUnit interface {
 	Units() []system.Unit
 }
 
-func ApplyHostname(hostname string) error {
+// ApplyHostname sets the hostname and, if it differs from the hostname, keeps
+// the FQDN as pretty hostname.
+func ApplyHostname(hostname, fqdn string) error {
 	if hostname == "" {
 		return nil
 	}
-	if err := system.SetHostname(hostname); err != nil {
+	pretty := ""
+	if fqdn != hostname {
+		pretty = fqdn
+	}
+	if err := system.SetHostname(hostname, pretty); err != nil {
 		return fmt.Errorf("error setting hostname: %w", err)
 	}
 	log.Printf("Set hostname to %s", hostname)
@@ -84,66 +92,48 @@ func ApplyNetworkConfig(ifaces []network.InterfaceGenerator, env *Environment) e
 // configuring the hostname, adding new users, writing various configuration
 // files to disk, and manipulating systemd services.
 func Apply(cfg config.CloudConfig, env *Environment) error {
-	for _, user := range cfg.Users {
-		if user.Name == "" {
-			log.Printf("User object has no 'name' field, skipping")
-			continue
-		}
-
-		if system.UserExists(&user) {
-			log.Printf("User '%s' exists, ignoring creation-time fields", user.Name)
-			if user.PasswordHash != "" {
-				log.Printf("Setting '%s' user's password", user.Name)
-				if err := system.SetUserPassword(user.Name, user.PasswordHash); err != nil {
-					log.Printf("Failed setting '%s' user's password: %v", user.Name, err)
-					return err
-				}
-			}
-		} else {
-			log.Printf("Creating user '%s'", user.Name)
-			if err := system.CreateUser(&user); err != nil {
-				log.Printf("Failed creating user '%s': %v", user.Name, err)
-				return err
-			}
-		}
+	if err := resolveOwners(cfg, env); err != nil {
+		return err
+	}
+	if err := applyUsers(cfg.Users, env); err != nil {
+		return err
+	}
 
-		// If this is the "core" user, we skip adding the ssh keys from SSHAuthorizedKeys. Those keys will
-		// be added later along any other keys we fetch from user-data and metadata.
-		if len(user.SSHAuthorizedKeys) > 0 && user.Name != "core" {
-			log.Printf("Authorizing %d SSH keys for user '%s'", len(user.SSHAuthorizedKeys), user.Name)
-			if err := system.AuthorizeSSHKeys(user.Name, env.SSHKeyName(), user.SSHAuthorizedKeys); err != nil {
-				return err
-			}
-		}
-		if user.SSHImportGithubUser != "" {
-			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", user.SSHImportGithubUser, user.Name)
-			if err := SSHImportGithubUser(user.Name, user.SSHImportGithubUser); err != nil {
-				return err
-			}
-		}
-		for _, u := range user.SSHImportGithubUsers {
-			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", u, user.Name)
-			if err := SSHImportGithubUser(user.Name, u); err != nil {
-				return err
-			}
-		}
-		if user.SSHImportURL != "" {
-			log.Printf("Authorizing SSH keys for CoreOS user '%s' from '%s'", user.Name, user.SSHImportURL)
-			if err := SSHImportKeysFromURL(user.Name, user.SSHImportURL); err != nil {
-				return err
-			}
-		}
+	if err := system.SetTimezone(cfg.Timezone, env.Root()); err != nil {
+		return err
+	}
+	if err := system.SetLocale(cfg.Locale, env.Root()); err != nil {
+		return err
+	}
+	if err := system.SetKeymap(cfg.Keymap, env.Root()); err != nil {
+		return err
 	}
 
-	var writeFiles []system.File
+	// Deferred files are written once the units have been processed, so
+	// that they may be owned by users the units create.
+	var writeFiles, deferredFiles []system.File
 	for _, file := range cfg.WriteFiles {
-		writeFiles = append(writeFiles, system.File{File: file})
+		if file.Defer {
+			deferredFiles = append(deferredFiles, system.File{File: file})
+		} else {
+			writeFiles = append(writeFiles, system.File{File: file})
+		}
 	}
 
 	for _, ccf := range []CloudConfigFile{
 		system.OEM{OEM: cfg.CoreOS.OEM},
 		system.Update{Update: cfg.CoreOS.Update, ReadConfig: system.DefaultReadConfig},
-		system.EtcHosts{EtcHosts: cfg.ManageEtcHosts},
+		system.NTP{NTP: cfg.NTP},
+		system.Resolved{Resolved: cfg.Resolved},
+		system.Sysctl{Sysctl: cfg.Sysctl},
+		system.EtcHosts{
+			EtcHosts:    cfg.ManageEtcHosts,
+			Hostname:    env.Hostname(),
+			FQDN:        env.FQDN(),
+			PrivateIPv4: env.PrivateIPv4(),
+			Template:    cfg.EtcHostsTemplate,
+			Substitute:  env.Apply,
+		},
 		system.Flannel{Flannel: cfg.CoreOS.Flannel},
 	} {
 		f, err := ccf.File()
@@ -155,6 +145,15 @@ func Apply(cfg config.CloudConfig, env *Environment) error {
 		}
 	}
 
+	caCerts := system.CACerts{CACerts: cfg.CACerts}
+	writeFiles = append(writeFiles, caCerts.Files()...)
+	kernelModules := system.KernelModules{KernelModules: cfg.KernelModules}
+	moduleFiles, err := kernelModules.Files()
+	if err != nil {
+		return err
+	}
+	writeFiles = append(writeFiles, moduleFiles...)
+
 	var units []system.Unit
 	for _, u := range cfg.CoreOS.Units {
 		units = append(units, system.Unit{Unit: u})
@@ -166,20 +165,44 @@ func Apply(cfg config.CloudConfig, env *Environment) error {
 		system.Fleet{Fleet: cfg.CoreOS.Fleet},
 		system.Locksmith{Locksmith: cfg.CoreOS.Locksmith},
 		system.Update{Update: cfg.CoreOS.Update, ReadConfig: system.DefaultReadConfig},
+		system.NTP{NTP: cfg.NTP},
+		system.Resolved{Resolved: cfg.Resolved},
+		caCerts,
+		// Modules are loaded first, for their parameters to be set.
+		kernelModules,
+		system.Sysctl{Sysctl: cfg.Sysctl},
 	} {
 		units = append(units, ccu.Units()...)
 	}
 
 	wroteEnvironment := false
-	for _, file := range writeFiles {
-		fullPath, err := system.WriteFile(&file, env.Root())
-		if err != nil {
-			return err
-		}
+	for _, file := range append(writeFiles, deferredFiles...) {
 		if path.Clean(file.Path) == "/etc/environment" {
 			wroteEnvironment = true
 		}
-		log.Printf("Wrote file %s to filesystem", fullPath)
+	}
+	if cfg.CACerts.RemoveDefaults {
+		if err := system.RemoveDefaultCACerts(env.Root()); err != nil {
+			return err
+		}
+	}
+	pruneUnits, err := caCerts.Prune(env.Root())
+	if err != nil {
+		return err
+	}
+	units = append(units, pruneUnits...)
+	for _, d := range cfg.Directories {
+		if _, err := system.WriteDirectory(&system.Directory{Directory: d}, env.Root()); err != nil {
+			return err
+		}
+	}
+	if err := writeCloudConfigFiles(writeFiles, env); err != nil {
+		return err
+	}
+	for _, l := range cfg.Links {
+		if _, err := system.WriteLink(&system.Link{Link: l}, env.Root()); err != nil {
+			return err
+		}
 	}
 
 	if !wroteEnvironment {
@@ -194,7 +217,153 @@ func Apply(cfg config.CloudConfig, env *Environment) error {
 	}
 
 	um := system.NewUnitManager(env.Root())
-	return processUnits(units, env.Root(), um)
+	if err := processUnits(units, env.Root(), um); err != nil {
+		return err
+	}
+	return writeCloudConfigFiles(deferredFiles, env)
+}
+
+// writeCloudConfigFiles writes the files of write_files, downloading the
+// content of those with a source first. The owners of all of the files are
+// resolved before any is written.
+func writeCloudConfigFiles(files []system.File, env *Environment) error {
+	for _, file := range files {
+		if _, _, err := system.LookupOwner(file.Owner, env.Root()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Path, err)
+		}
+	}
+	for _, file := range files {
+		if file.Source.URI != "" {
+			content, err := fetchFileSource(file.Source)
+			if err != nil {
+				return fmt.Errorf("failed to download %s: %w", file.Path, err)
+			}
+			file.Content = string(content)
+		}
+		fullPath, err := system.WriteFile(&file, env.Root())
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote file %s to filesystem", fullPath)
+	}
+	return nil
+}
+
+// resolveOwners resolves the owners of the files, directories and links
+// written before the units are processed, so that an unknown owner fails the
+// cloud-config before anything is applied. The users of the cloud-config,
+// and their login groups, may not exist yet and are left out.
+func resolveOwners(cfg config.CloudConfig, env *Environment) error {
+	users := map[string]bool{}
+	for _, u := range cfg.Users {
+		users[u.Name] = u.Name != ""
+	}
+
+	var owners [][2]string
+	for _, f := range cfg.WriteFiles {
+		if !f.Defer {
+			owners = append(owners, [2]string{f.Path, f.Owner})
+		}
+	}
+	for _, d := range cfg.Directories {
+		owners = append(owners, [2]string{d.Path, d.Owner})
+	}
+	for _, l := range cfg.Links {
+		owners = append(owners, [2]string{l.Path, l.Owner})
+	}
+
+	for _, o := range owners {
+		user, group, hasGroup := strings.Cut(o[1], ":")
+		if users[user] {
+			user = ""
+		}
+		if users[group] {
+			group = ""
+		}
+		owner := user
+		if hasGroup {
+			owner += ":" + group
+		}
+		if _, _, err := system.LookupOwner(owner, env.Root()); err != nil {
+			return fmt.Errorf("failed to resolve the owner of %s: %w", o[0], err)
+		}
+	}
+	return nil
+}
+
+// fetchFileSource downloads the content of a file and verifies its checksum.
+func fetchFileSource(source config.FileSource) ([]byte, error) {
+	data, err := pkg.NewHttpClientHeader(source.Headers.Header()).Fetch(source.URI)
+	if err != nil {
+		return nil, err
+	}
+	if source.Checksum != "" {
+		checksum, err := pkg.ParseChecksum(source.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if err := checksum.Verify(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// applyUsers creates the users which don't exist yet, sets the password of
+// the others and authorizes their SSH keys, except those of "core" which are
+// authorized along with the keys of the metadata.
+func applyUsers(users []config.User, env *Environment) error {
+	for _, user := range users {
+		if user.Name == "" {
+			log.Printf("User object has no 'name' field, skipping")
+			continue
+		}
+
+		if system.UserExists(&user) {
+			log.Printf("User '%s' exists, ignoring creation-time fields", user.Name)
+			if user.PasswordHash != "" {
+				log.Printf("Setting '%s' user's password", user.Name)
+				if err := system.SetUserPassword(user.Name, user.PasswordHash); err != nil {
+					log.Printf("Failed setting '%s' user's password: %v", user.Name, err)
+					return err
+				}
+			}
+		} else {
+			log.Printf("Creating user '%s'", user.Name)
+			if err := system.CreateUser(&user); err != nil {
+				log.Printf("Failed creating user '%s': %v", user.Name, err)
+				return err
+			}
+		}
+
+		// If this is the "core" user, we skip adding the ssh keys from SSHAuthorizedKeys. Those keys will
+		// be added later along any other keys we fetch from user-data and metadata.
+		if len(user.SSHAuthorizedKeys) > 0 && user.Name != "core" {
+			log.Printf("Authorizing %d SSH keys for user '%s'", len(user.SSHAuthorizedKeys), user.Name)
+			if err := system.AuthorizeSSHKeys(user.Name, env.SSHKeyName(), user.SSHAuthorizedKeys); err != nil {
+				return err
+			}
+		}
+		if user.SSHImportGithubUser != "" {
+			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", user.SSHImportGithubUser, user.Name)
+			if err := SSHImportGithubUser(user.Name, user.SSHImportGithubUser); err != nil {
+				return err
+			}
+		}
+		for _, u := range user.SSHImportGithubUsers {
+			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", u, user.Name)
+			if err := SSHImportGithubUser(user.Name, u); err != nil {
+				return err
+			}
+		}
+		if user.SSHImportURL != "" {
+			log.Printf("Authorizing SSH keys for CoreOS user '%s' from '%s'", user.Name, user.SSHImportURL)
+			if err := SSHImportKeysFromURL(user.Name, user.SSHImportURL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func createNetworkingUnits(interfaces []network.InterfaceGenerator) (units []system.Unit) {
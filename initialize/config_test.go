@@ -15,10 +15,13 @@
 package initialize
 
 import (
+	"os"
+	"path"
 	"reflect"
 	"testing"
 
 	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/datasource"
 	"github.com/flatcar/coreos-cloudinit/network"
 	"github.com/flatcar/coreos-cloudinit/system"
 )
@@ -297,3 +300,105 @@ func TestProcessUnits(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteCloudConfigFiles(t *testing.T) {
+	tests := []struct {
+		file config.File
+
+		contents string
+		err      bool
+	}{
+		{
+			file:     config.File{Path: "/etc/inline", Content: "inline"},
+			contents: "inline",
+		},
+		{
+			file:     config.File{Path: "/etc/source", Source: config.FileSource{URI: "data:,source"}},
+			contents: "source",
+		},
+		{
+			file: config.File{Path: "/etc/checksum", Source: config.FileSource{
+				URI:      "data:,source",
+				Checksum: "sha256=4eb9ee6d8f7e4bb1dbc4a9d8a2da3db5ff1e0c3dc5a6bc1b52cb1292bb9c1ad4",
+			}},
+			err: true,
+		},
+		{
+			file: config.File{Path: "/etc/missing", Source: config.FileSource{URI: "file:///nonexistent"}},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		root := t.TempDir()
+		env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{})
+		err := writeCloudConfigFiles([]system.File{{File: tt.file}}, env)
+		if tt.err != (err != nil) {
+			t.Errorf("bad error (%+v): want error %t, got %v", tt.file, tt.err, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		contents, err := os.ReadFile(path.Join(root, tt.file.Path))
+		if err != nil {
+			t.Errorf("bad file (%+v): %v", tt.file, err)
+		} else if string(contents) != tt.contents {
+			t.Errorf("bad contents (%+v): want %q, got %q", tt.file, tt.contents, contents)
+		}
+	}
+}
+
+func TestResolveOwners(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(root, "etc/passwd"), []byte("core:x:500:500::/home/core:/bin/bash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(root, "etc/group"), []byte("core:x:500:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{})
+
+	tests := []struct {
+		config config.CloudConfig
+
+		err bool
+	}{
+		{
+			config: config.CloudConfig{WriteFiles: []config.File{{Path: "/etc/a", Owner: "core:core"}}},
+		},
+		{
+			config: config.CloudConfig{WriteFiles: []config.File{{Path: "/etc/a", Owner: "nobody"}}},
+			err:    true,
+		},
+		{
+			// Deferred files may be owned by users the units create.
+			config: config.CloudConfig{WriteFiles: []config.File{{Path: "/etc/a", Owner: "nobody", Defer: true}}},
+		},
+		{
+			config: config.CloudConfig{Directories: []config.Directory{{Path: "/var/lib/app", Owner: ":app"}}},
+			err:    true,
+		},
+		{
+			config: config.CloudConfig{Links: []config.Link{{Path: "/etc/link", Target: "/etc/a", Owner: "app:"}}},
+			err:    true,
+		},
+		{
+			// The users of the cloud-config are created first.
+			config: config.CloudConfig{
+				Users:       []config.User{{Name: "app"}},
+				Directories: []config.Directory{{Path: "/var/lib/app", Owner: "app:app"}},
+				Links:       []config.Link{{Path: "/etc/link", Target: "/etc/a", Owner: "app:"}},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		if err := resolveOwners(tt.config, env); tt.err != (err != nil) {
+			t.Errorf("bad error (%d): want error %t, got %v", i, tt.err, err)
+		}
+	}
+}
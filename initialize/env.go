@@ -19,7 +19,9 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 	"github.com/flatcar/coreos-cloudinit/datasource"
@@ -28,12 +30,26 @@ import (
 
 const DefaultSSHKeyName = "coreos-cloudinit"
 
+// DefaultScriptTimeout is how long the scripts and commands which are waited
+// for may run by default.
+const DefaultScriptTimeout = 10 * time.Minute
+
 type Environment struct {
 	root          string
 	configRoot    string
 	workspace     string
 	sshKeyName    string
+	instanceID    string
+	hostname      string
+	fqdn          string
 	substitutions map[string]string
+	trustedKeys   config.TrustedKeys
+	// signed is set while parsing content covered by a verified signature,
+	// whose includes must then pin what they include with a digest.
+	signed bool
+
+	waitForScripts bool
+	scriptTimeout  time.Duration
 }
 
 // TODO(jonboulle): this is getting unwieldy, should be able to simplify the interface somehow
@@ -50,7 +66,7 @@ func NewEnvironment(root, configRoot, workspace, sshKeyName string, metadata dat
 		"$public_ipv6":  firstNonNull(metadata.PublicIPv6, os.Getenv("COREOS_PUBLIC_IPV6")),
 		"$private_ipv6": firstNonNull(metadata.PrivateIPv6, os.Getenv("COREOS_PRIVATE_IPV6")),
 	}
-	return &Environment{root, configRoot, workspace, sshKeyName, substitutions}
+	return &Environment{root, configRoot, workspace, sshKeyName, metadata.InstanceID, "", "", substitutions, nil, false, false, DefaultScriptTimeout}
 }
 
 func (e *Environment) Workspace() string {
@@ -73,6 +89,70 @@ func (e *Environment) SetSSHKeyName(name string) {
 	e.sshKeyName = name
 }
 
+// InstanceID returns the ID of the instance as given by the datasource,
+// falling back to the machine ID which is generated on first boot.
+func (e *Environment) InstanceID() string {
+	if e.instanceID != "" {
+		return e.instanceID
+	}
+	return system.MachineID(e.root)
+}
+
+// instanceKey returns the instance ID as the name of the directories
+// recording what was done once per instance, "unknown" if there is no ID.
+func (e *Environment) instanceKey() string {
+	if id := e.InstanceID(); id != "" {
+		return id
+	}
+	return "unknown"
+}
+
+// RequireSignatures makes user-data parsing reject every part that isn't
+// signed by one of the given keys.
+func (e *Environment) RequireSignatures(keys config.TrustedKeys) {
+	if keys == nil {
+		keys = config.TrustedKeys{}
+	}
+	e.trustedKeys = keys
+}
+
+func (e *Environment) RequiresSignatures() bool {
+	return e.trustedKeys != nil
+}
+
+// WaitForScripts makes script parts run one after the other, each waiting
+// for the previous one to exit, for at most the script timeout.
+func (e *Environment) WaitForScripts() {
+	e.waitForScripts = true
+}
+
+// SetScriptTimeout sets how long the script parts which are waited for, and
+// the commands of bootcmd and runcmd, may run unless their options say
+// otherwise. Zero disables the timeout.
+func (e *Environment) SetScriptTimeout(timeout time.Duration) {
+	e.scriptTimeout = timeout
+}
+
+// SetHostname records the hostname and FQDN the run determined, for the
+// configuration generated from them.
+func (e *Environment) SetHostname(hostname, fqdn string) {
+	e.hostname = hostname
+	e.fqdn = fqdn
+}
+
+func (e *Environment) Hostname() string {
+	return e.hostname
+}
+
+func (e *Environment) FQDN() string {
+	return e.fqdn
+}
+
+// PrivateIPv4 returns the private IPv4 address of the metadata, if any.
+func (e *Environment) PrivateIPv4() string {
+	return e.substitutions["$private_ipv4"]
+}
+
 // Apply goes through the map of substitutions and replaces all instances of
 // the keys with their respective values. It supports escaping substitutions
 // with a leading '\'.
@@ -89,24 +169,57 @@ func (e *Environment) Apply(data string) string {
 	return data
 }
 
-func (e *Environment) DefaultEnvironmentFile() *system.EnvFile {
-	ef := system.EnvFile{
-		File: &system.File{File: config.File{
-			Path: "/etc/environment",
-		}},
-		Vars: map[string]string{},
-	}
+func (e *Environment) metadataVars() map[string]string {
+	vars := map[string]string{}
 	if ip, ok := e.substitutions["$public_ipv4"]; ok && len(ip) > 0 {
-		ef.Vars["COREOS_PUBLIC_IPV4"] = ip
+		vars["COREOS_PUBLIC_IPV4"] = ip
 	}
 	if ip, ok := e.substitutions["$private_ipv4"]; ok && len(ip) > 0 {
-		ef.Vars["COREOS_PRIVATE_IPV4"] = ip
+		vars["COREOS_PRIVATE_IPV4"] = ip
 	}
 	if ip, ok := e.substitutions["$public_ipv6"]; ok && len(ip) > 0 {
-		ef.Vars["COREOS_PUBLIC_IPV6"] = ip
+		vars["COREOS_PUBLIC_IPV6"] = ip
 	}
 	if ip, ok := e.substitutions["$private_ipv6"]; ok && len(ip) > 0 {
-		ef.Vars["COREOS_PRIVATE_IPV6"] = ip
+		vars["COREOS_PRIVATE_IPV6"] = ip
+	}
+	return vars
+}
+
+// ScriptEnvironment returns the variables set for user-data scripts: the
+// addresses of the metadata, as in /etc/environment, and the INSTANCE_ID.
+func (e *Environment) ScriptEnvironment() []string {
+	vars := e.metadataVars()
+	vars["INSTANCE_ID"] = e.InstanceID()
+
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// scriptOptions completes the options of a script or command with the
+// ScriptEnvironment, substitutes the metadata in its environment and, if it
+// is waited for, sets the default timeout.
+func (e *Environment) scriptOptions(opts config.ScriptOptions, waited bool) config.ScriptOptions {
+	opts.Environment = append(e.ScriptEnvironment(), opts.Environment...)
+	for i, v := range opts.Environment {
+		opts.Environment[i] = e.Apply(v)
+	}
+	if waited && opts.Timeout == "" {
+		opts.Timeout = e.scriptTimeout.String()
+	}
+	return opts
+}
+
+func (e *Environment) DefaultEnvironmentFile() *system.EnvFile {
+	ef := system.EnvFile{
+		File: &system.File{File: config.File{
+			Path: "/etc/environment",
+		}},
+		Vars: e.metadataVars(),
 	}
 	if len(ef.Vars) == 0 {
 		return nil
@@ -0,0 +1,269 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/pkg"
+	"github.com/flatcar/coreos-cloudinit/system"
+)
+
+// runIgnition applies the Ignition config of the part once per instance,
+// since Ignition configs are only meant to be applied on the first boot.
+func (udp *UserDataPart) runIgnition(env *Environment) error {
+	if env == nil {
+		return fmt.Errorf("environment is nil")
+	}
+
+	ign := udp.ignition
+	if ign == nil {
+		var err error
+		if ign, err = config.NewIgnition(udp.contents); errors.Is(err, config.ErrLegacyIgnition) {
+			log.Printf("Ignoring part %q: %v", udp.PartName(), err)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error parsing Ignition config: %w", err)
+		}
+	}
+
+	guard := path.Join("ignition", env.instanceKey(), fmt.Sprintf("%x", sha256.Sum256([]byte(udp.contents))))
+	if _, err := os.Stat(path.Join(env.Workspace(), guard)); err == nil {
+		log.Printf("Ignition config %q has already been applied on this instance", udp.PartName())
+		return nil
+	}
+
+	if unsupported, err := config.UnsupportedIgnitionKeys(udp.contents); err == nil && len(unsupported) > 0 {
+		log.Printf("Ignoring unsupported keys of Ignition config %q: %s", udp.PartName(), strings.Join(unsupported, ", "))
+	}
+	if err := ApplyIgnition(*ign, env); err != nil {
+		return fmt.Errorf("error applying Ignition config: %w", err)
+	}
+
+	file := system.File{File: config.File{
+		Path:               guard,
+		RawFilePermissions: "0644",
+		Content:            udp.PartName() + "\n",
+	}}
+	_, err := system.WriteFile(&file, env.Workspace())
+	return err
+}
+
+// ApplyIgnition applies the files, directories, links, systemd units and
+// users of an Ignition config. Enabled units are also started, as they would
+// have been had the config been applied by Ignition before they were.
+func ApplyIgnition(ign config.Ignition, env *Environment) error {
+	var users []config.User
+	for _, u := range ign.Passwd.Users {
+		user := config.User{
+			Name:              u.Name,
+			SSHAuthorizedKeys: u.SSHAuthorizedKeys,
+			GECOS:             u.Gecos,
+			Homedir:           u.HomeDir,
+			NoCreateHome:      u.NoCreateHome,
+			PrimaryGroup:      u.PrimaryGroup,
+			Groups:            u.Groups,
+			NoUserGroup:       u.NoUserGroup,
+			System:            u.System,
+			NoLogInit:         u.NoLogInit,
+			Shell:             u.Shell,
+		}
+		if u.PasswordHash != nil {
+			user.PasswordHash = *u.PasswordHash
+		}
+		users = append(users, user)
+	}
+	if err := applyUsers(users, env); err != nil {
+		return err
+	}
+
+	for _, d := range ign.Storage.Directories {
+		if !d.OnRoot() {
+			log.Printf("Skipping directory %q on unsupported filesystem %q", d.Path, d.Filesystem)
+			continue
+		}
+		mode := os.FileMode(0755)
+		if d.Mode != nil {
+			mode = os.FileMode(*d.Mode)
+		}
+		if _, err := system.MakeDirectory(d.Path, mode, d.Owner(), env.Root()); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range ign.Storage.Files {
+		if !f.OnRoot() {
+			log.Printf("Skipping file %q on unsupported filesystem %q", f.Path, f.Filesystem)
+			continue
+		}
+		if err := writeIgnitionFile(f, ign.Version(), env); err != nil {
+			return fmt.Errorf("error writing %q: %w", f.Path, err)
+		}
+	}
+
+	for _, l := range ign.Storage.Links {
+		if !l.OnRoot() {
+			log.Printf("Skipping link %q on unsupported filesystem %q", l.Path, l.Filesystem)
+			continue
+		}
+		// Links are only replaced by default in version 2.
+		overwrite := ign.Version() == 2
+		if l.Overwrite != nil {
+			overwrite = *l.Overwrite
+		}
+		if _, err := system.MakeLink(l.Path, l.Target, l.Hard, overwrite, l.Owner(), env.Root()); err != nil {
+			return err
+		}
+	}
+
+	var units []system.Unit
+	for _, u := range ign.Systemd.Units {
+		unit := config.Unit{
+			Name:    u.Name,
+			Content: u.Contents,
+			Enable:  u.IsEnabled(),
+			Mask:    u.Mask,
+		}
+		if unit.Enable && !unit.Mask {
+			unit.Command = "start"
+		}
+		for _, d := range u.Dropins {
+			unit.DropIns = append(unit.DropIns, config.UnitDropIn{Name: d.Name, Content: d.Contents})
+		}
+		units = append(units, system.Unit{Unit: unit})
+	}
+	return processUnits(units, env.Root(), system.NewUnitManager(env.Root()))
+}
+
+func writeIgnitionFile(f config.IgnitionFile, version int, env *Environment) error {
+	// Files are only replaced by default in version 2.
+	overwrite := version == 2
+	if f.Overwrite != nil {
+		overwrite = *f.Overwrite
+	}
+
+	appending, appends, err := f.Appends()
+	if err != nil {
+		return err
+	}
+
+	existing, err := ioutil.ReadFile(path.Join(env.Root(), f.Path))
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var (
+		contents []byte
+		keep     bool
+	)
+	switch {
+	case version == 2 && appending:
+		contents = existing
+	case f.Contents.Source == "" && exists:
+		// Version 3 keeps the existing contents when none are given.
+		if !appending && !overwrite {
+			return nil
+		}
+		contents = existing
+	case exists && !overwrite:
+		// The file may have been written from the same config by Ignition
+		// itself, in which case it is already applied.
+		keep = true
+	}
+	if f.Contents.Source != "" {
+		data, err := fetchIgnitionResource(f.Contents)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, data...)
+	}
+	for _, r := range appends {
+		data, err := fetchIgnitionResource(r)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, data...)
+	}
+	if keep {
+		if !bytes.Equal(contents, existing) {
+			return fmt.Errorf("file already exists")
+		}
+		log.Printf("File %s already has the contents of the config", f.Path)
+		return nil
+	}
+
+	perm := "0644"
+	if f.Mode != nil {
+		perm = fmt.Sprintf("%04o", *f.Mode)
+	}
+	file := system.File{File: config.File{
+		Path:               f.Path,
+		Content:            string(contents),
+		RawFilePermissions: perm,
+		Owner:              f.Owner(),
+	}}
+	fullPath, err := system.WriteFile(&file, env.Root())
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote file %s to filesystem", fullPath)
+	return nil
+}
+
+// fetchIgnitionResource fetches the contents of a file, verifies their hash
+// and decompresses them.
+func fetchIgnitionResource(r config.IgnitionResource) ([]byte, error) {
+	data, err := pkg.NewHttpClient().Fetch(r.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	// The hash is computed on the compressed data.
+	if r.Verification.Hash != "" {
+		checksum, err := pkg.ParseChecksum(r.Verification.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := checksum.Verify(data); err != nil {
+			return nil, err
+		}
+	}
+
+	switch r.Compression {
+	case "":
+		return data, nil
+	case "gzip":
+		gzr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		return io.ReadAll(gzr)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", r.Compression)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/datasource"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunIgnition(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(root, "etc/existing"), []byte("kept"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(root, "etc/appended"), []byte("a"), 0644))
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte("compressed"))
+	gz.Close()
+	compressed := "data:;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	payload := fmt.Sprintf(`{
+  "ignition": {"version": "3.3.0"},
+  "storage": {
+    "directories": [{"path": "/var/lib/app", "mode": 448}],
+    "files": [
+      {"path": "/etc/motd", "mode": 384, "contents": {"source": "data:,hello%%20world"}},
+      {"path": "/etc/compressed", "contents": {"source": %q, "compression": "gzip", "verification": {"hash": "sha512-%x"}}},
+      {"path": "/etc/existing"},
+      {"path": "/etc/appended", "append": [{"source": "data:,b"}, {"source": "data:,c"}]}
+    ],
+    "links": [{"path": "/etc/link", "target": "/etc/motd"}]
+  }
+}`, compressed, sha512.Sum512(buf.Bytes()))
+
+	for i := 0; i < 2; i++ {
+		env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+		udata, err := NewUserData(payload, env)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(udata.Parts))
+		require.True(t, udata.Parts[0].IsIgnition())
+		// The config is only applied once, so the file is only appended to
+		// once.
+		require.NoError(t, udata.Parts[0].RunPart(env))
+	}
+
+	for file, contents := range map[string]string{
+		"etc/motd":       "hello world",
+		"etc/compressed": "compressed",
+		"etc/existing":   "kept",
+		"etc/appended":   "abc",
+	} {
+		data, err := os.ReadFile(path.Join(root, file))
+		require.NoError(t, err)
+		require.Equal(t, contents, string(data), file)
+	}
+	target, err := os.Readlink(path.Join(root, "etc/link"))
+	require.NoError(t, err)
+	require.Equal(t, "/etc/motd", target)
+
+	fi, err := os.Stat(path.Join(root, "etc/motd"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	fi, err = os.Stat(path.Join(root, "var/lib/app"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+}
+
+func TestRunIgnitionRefusesToOverwrite(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(root, "etc/motd"), []byte("old"), 0644))
+
+	for _, tt := range []struct {
+		version   string
+		overwrite string
+		err       bool
+		contents  string
+	}{
+		{"3.0.0", "", true, "other"},
+		{"3.0.0", `"overwrite": true,`, false, "new"},
+		{"2.2.0", "", false, "newer"},
+	} {
+		payload := fmt.Sprintf(`{"ignition": {"version": %q}, "storage": {"files": [{%s "path": "/etc/motd", "contents": {"source": "data:,%s"}}]}}`, tt.version, tt.overwrite, tt.contents)
+		env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+		udata, err := NewUserData(payload, env)
+		require.NoError(t, err)
+		if tt.err {
+			require.Error(t, udata.Parts[0].RunPart(env))
+		} else {
+			require.NoError(t, udata.Parts[0].RunPart(env))
+		}
+		data, err := os.ReadFile(path.Join(root, "etc/motd"))
+		require.NoError(t, err)
+		require.Equal(t, map[bool]string{true: "old", false: tt.contents}[tt.err], string(data))
+	}
+}
+
+func TestRunIgnitionAlreadyApplied(t *testing.T) {
+	// Ignition has already applied the config.
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(root, "etc/motd"), []byte("hello"), 0644))
+	require.NoError(t, os.Symlink("/etc/motd", path.Join(root, "etc/link")))
+
+	payload := `{
+  "ignition": {"version": "3.3.0"},
+  "storage": {
+    "directories": [{"path": "/etc"}],
+    "files": [{"path": "/etc/motd", "contents": {"source": "data:,hello"}}],
+    "links": [{"path": "/etc/link", "target": "/etc/motd"}]
+  }
+}`
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+	udata, err := NewUserData(payload, env)
+	require.NoError(t, err)
+	require.NoError(t, udata.Parts[0].RunPart(env))
+
+	data, err := os.ReadFile(path.Join(root, "etc/motd"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestRunIgnitionSkipsOtherFilesystems(t *testing.T) {
+	root := t.TempDir()
+	payload := `{
+  "ignition": {"version": "2.2.0"},
+  "storage": {
+    "directories": [{"filesystem": "oem", "path": "/oem-dir"}],
+    "files": [
+      {"filesystem": "oem", "path": "/grub.cfg", "contents": {"source": "data:,oem"}},
+      {"filesystem": "root", "path": "/etc/motd", "contents": {"source": "data:,root"}}
+    ],
+    "links": [{"filesystem": "oem", "path": "/oem-link", "target": "/grub.cfg"}]
+  }
+}`
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+	udata, err := NewUserData(payload, env)
+	require.NoError(t, err)
+	require.NoError(t, udata.Parts[0].RunPart(env))
+
+	data, err := os.ReadFile(path.Join(root, "etc/motd"))
+	require.NoError(t, err)
+	require.Equal(t, "root", string(data))
+	for _, name := range []string{"oem-dir", "grub.cfg", "oem-link"} {
+		_, err := os.Lstat(path.Join(root, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	neturl "net/url"
+	"os"
+	"path"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+	"github.com/flatcar/coreos-cloudinit/pkg"
+	"github.com/flatcar/coreos-cloudinit/system"
+)
+
+// MaxIncludeDepth limits how deeply included user-data may itself include
+// other user-data.
+const MaxIncludeDepth = 5
+
+// includeToUserDataParts fetches every URL of an "#include" or
+// "#include-once" user-data and parses the contents as user-data. As the
+// signature of signed user-data covers the URLs only, their contents must
+// then be pinned by a digest fragment.
+func includeToUserDataParts(payload string, env *Environment, depth int) ([]UserDataPart, error) {
+	if depth >= MaxIncludeDepth {
+		return nil, fmt.Errorf("includes are nested more than %d levels deep", MaxIncludeDepth)
+	}
+
+	include := config.NewInclude(payload)
+	if env.signed || env.RequiresSignatures() {
+		for _, url := range include.URLs {
+			if !pkg.HasChecksum(url) {
+				return nil, fmt.Errorf("%s has no digest fragment, which signed user-data requires", url)
+			}
+		}
+	}
+	client := pkg.NewHttpClient()
+	parts := []UserDataPart{}
+	for _, url := range include.URLs {
+		var data []byte
+		var err error
+		if include.Once {
+			data, err = fetchIncludeOnce(client, url, env)
+		} else {
+			log.Printf("Including user-data from %s", url)
+			data, err = client.Fetch(url)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error fetching %s: %w", url, err)
+		}
+
+		udParts, err := partsFromUserData(string(data), env, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", url, err)
+		}
+		// Multipart MIME names its own parts.
+		if !config.IsMultipartMime(string(data)) {
+			for i := range udParts {
+				udParts[i].fileName = includedPartName(url)
+			}
+		}
+		parts = append(parts, udParts...)
+	}
+	return parts, nil
+}
+
+// fetchIncludeOnce fetches the URL on the first boot of the instance and
+// returns the copy cached in the workspace on later boots.
+func fetchIncludeOnce(client *pkg.HttpClient, url string, env *Environment) ([]byte, error) {
+	cache := path.Join("include-once", env.instanceKey(), fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+	if data, err := ioutil.ReadFile(path.Join(env.Workspace(), cache)); err == nil {
+		log.Printf("Including user-data from %s (cached)", url)
+		return data, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Printf("Including user-data from %s", url)
+	data, err := client.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	file := system.File{File: config.File{
+		Path:               cache,
+		RawFilePermissions: "0600",
+		Content:            string(data),
+	}}
+	if _, err := system.WriteFile(&file, env.Workspace()); err != nil {
+		return nil, fmt.Errorf("error caching %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// includedPartName names a part after the last element of the URL it was
+// fetched from.
+func includedPartName(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return url
+	}
+	return path.Base(u.Path)
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/datasource"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserDataParsesInclude(t *testing.T) {
+	resources := map[string]string{
+		"/web.yaml": "#cloud-config\nhostname: web\n",
+		"/setup.sh": "#!/bin/bash\necho setup\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resources[r.URL.Path]))
+	}))
+	defer server.Close()
+	resources["/nested"] = "#include\n" + server.URL + "/web.yaml\n"
+	resources["/recursive"] = "#include\n" + server.URL + "/recursive\n"
+
+	env := NewEnvironment(t.TempDir(), "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+
+	udata, err := NewUserData("#include\n# comment\n"+server.URL+"/web.yaml\n\n"+server.URL+"/setup.sh\n", env)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(udata.Parts))
+	require.True(t, udata.Parts[0].IsCloudConfig())
+	require.Equal(t, "web.yaml", udata.Parts[0].PartName())
+	require.True(t, udata.Parts[1].IsScript())
+	require.Equal(t, "setup.sh", udata.Parts[1].PartName())
+	require.Equal(t, "web", udata.FindHostname())
+
+	udata, err = NewUserData("#include\n"+server.URL+"/nested\n", env)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(udata.Parts))
+	require.Equal(t, "web", udata.FindHostname())
+
+	_, err = NewUserData("#include\n"+server.URL+"/recursive\n", env)
+	require.ErrorContains(t, err, "nested more than")
+}
+
+func TestNewUserDataParsesIncludeOnce(t *testing.T) {
+	hostname := "first"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#cloud-config\nhostname: " + hostname + "\n"))
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	payload := "#include-once\n" + server.URL + "/config\n"
+
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+	udata, err := NewUserData(payload, env)
+	require.NoError(t, err)
+	require.Equal(t, "first", udata.FindHostname())
+
+	// Later boots of the same instance use the cached copy.
+	hostname = "second"
+	udata, err = NewUserData(payload, env)
+	require.NoError(t, err)
+	require.Equal(t, "first", udata.FindHostname())
+
+	env = NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-2"})
+	udata, err = NewUserData(payload, env)
+	require.NoError(t, err)
+	require.Equal(t, "second", udata.FindHostname())
+}
+
+func TestNewUserDataParsesIncludeOnceWithoutInstanceID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#cloud-config\nhostname: first\n"))
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	url := server.URL + "/config"
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{})
+	_, err := NewUserData("#include-once\n"+url+"\n", env)
+	require.NoError(t, err)
+	require.FileExists(t, path.Join(env.Workspace(), "include-once", "unknown", fmt.Sprintf("%x", sha256.Sum256([]byte(url)))))
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+// MergeCloudConfigs folds all cloud-config parts into a single one, in place
+// of the first of them, so that they are applied as a whole. Parts are
+// merged in order, according to their "merge_how" key or X-Merge-Type
+// header.
+func (ud *UserData) MergeCloudConfigs() error {
+	var (
+		docs  []string
+		hows  []config.MergeHow
+		names []string
+		first = -1
+	)
+	for i, part := range ud.Parts {
+		if !part.IsCloudConfig() {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+
+		how := config.DefaultMergeHow
+		if part.mergeType != "" {
+			var err error
+			if how, err = config.ParseMergeHow(part.mergeType); err != nil {
+				return fmt.Errorf("part %q: invalid X-Merge-Type: %w", part.PartName(), err)
+			}
+		}
+		docs = append(docs, part.contents)
+		hows = append(hows, how)
+		names = append(names, part.PartName())
+	}
+	if len(docs) < 2 {
+		return nil
+	}
+
+	contents, err := config.MergeCloudConfigs(docs, hows)
+	if err != nil {
+		return err
+	}
+	// The contents have already been substituted, so env.Apply must not
+	// be run again.
+	cc, err := config.NewCloudConfig(contents)
+	if err != nil {
+		return err
+	}
+	if err := cc.Decode(); err != nil {
+		return err
+	}
+	log.Printf("Merged %d cloud-config parts (%s): %s", len(docs), strings.Join(names, ", "), config.SummarizeCloudConfig(contents))
+
+	merged := make([]UserDataPart, 0, len(ud.Parts)-len(docs)+1)
+	for i, part := range ud.Parts {
+		if i == first {
+			merged = append(merged, UserDataPart{
+				userDataType: CloudConfigType,
+				contents:     contents,
+				cloudConfig:  cc,
+				fileName:     "cloud-config.yaml",
+				verified:     true,
+			})
+		} else if !part.IsCloudConfig() {
+			merged = append(merged, part)
+		}
+	}
+	ud.Parts = merged
+	return nil
+}
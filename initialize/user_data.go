@@ -26,10 +26,16 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 	"github.com/flatcar/coreos-cloudinit/system"
+
+	"gopkg.in/yaml.v3"
 )
 
 type UserDataType string
@@ -37,6 +43,7 @@ type UserDataType string
 const (
 	CloudConfigType UserDataType = "cloud-config"
 	ScriptType      UserDataType = "script"
+	BoothookType    UserDataType = "boothook"
 	IgnitionType    UserDataType = "ignition"
 	UnknownType     UserDataType = "unknown"
 )
@@ -50,17 +57,53 @@ func NewUserData(payload string, env *Environment) (*UserData, error) {
 		return nil, fmt.Errorf("environment is nil")
 	}
 
-	parts, err := partsFromUserData(payload, env)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing user-data: %w", err)
+	var parts []UserDataPart
+	if env.RequiresSignatures() && !config.IsMultipartMime(payload) {
+		// User-data that can't carry a signature isn't even parsed, so
+		// that nothing is decrypted or fetched on its behalf.
+		parts = []UserDataPart{{
+			userDataType: UnknownType,
+			fileName:     "userdata.txt",
+			signatureErr: config.ErrUnsigned,
+		}}
+	} else {
+		var err error
+		if parts, err = partsFromUserData(payload, env, 0); err != nil {
+			return nil, fmt.Errorf("error parsing user-data: %w", err)
+		}
 	}
-	return &UserData{
-		Parts: parts,
-		env:   env,
-	}, nil
+
+	ud := &UserData{env: env}
+	for i, part := range parts {
+		part.index = i + 1
+		if env.RequiresSignatures() && !part.verified {
+			err := part.signatureErr
+			if err == nil {
+				err = config.ErrUnsigned
+			}
+			log.Printf("Rejecting part %q: %v", part.PartName(), err)
+			ud.Rejected = append(ud.Rejected, fmt.Errorf("part %q: %w", part.PartName(), err))
+			continue
+		}
+		ud.Parts = append(ud.Parts, part)
+	}
+
+	// The "scripts" sections of the cloud-configs are the defaults of the
+	// options given in the headers of the scripts.
+	for _, part := range ud.Parts {
+		if part.cloudConfig != nil {
+			ud.scriptDefaults = ud.scriptDefaults.Override(part.cloudConfig.Scripts)
+		}
+	}
+	for i := range ud.Parts {
+		if ud.Parts[i].userDataType == ScriptType {
+			ud.Parts[i].scriptOptions = ud.scriptDefaults.Override(ud.Parts[i].scriptOptions)
+		}
+	}
+	return ud, nil
 }
 
-func multipartToUserDataParts(payload string, env *Environment) ([]UserDataPart, error) {
+func multipartToUserDataParts(payload string, env *Environment, depth int) ([]UserDataPart, error) {
 	if env == nil {
 		return nil, fmt.Errorf("environment is nil")
 	}
@@ -112,57 +155,204 @@ func multipartToUserDataParts(payload string, env *Environment) ([]UserDataPart,
 				return []UserDataPart{}, fmt.Errorf("error decoding base64: %w", err)
 			}
 		}
-		switch partHeader.mediaType {
-		case "text/cloud-config":
-			part, err := payloadAsCloudConfigPart(partHeader.fileName, string(body), env)
-			if err != nil {
-				return []UserDataPart{}, fmt.Errorf("error parsing cloud-config: %w", err)
-			}
 
-			udParts = append(udParts, part)
-			continue
-		case "text/x-shellscript":
-			part, err := payloadAsScriptPart(partHeader.fileName, string(body), env)
-			if err != nil {
-				return []UserDataPart{}, fmt.Errorf("error parsing script: %w", err)
+		// The signature of a part covers everything nested in it, which is
+		// only parsed once the signature has been verified.
+		partEnv := env
+		if env.RequiresSignatures() {
+			if err := env.trustedKeys.Verify(config.SignedData(part.Header, body), part.Header.Get(config.SignatureHeader)); err != nil {
+				udParts = append(udParts, UserDataPart{
+					userDataType: UnknownType,
+					fileName:     partHeader.fileName,
+					signatureErr: err,
+				})
+				continue
 			}
-			udParts = append(udParts, part)
-			continue
-		case "application/gzip",
-			"application/gzip-compressed",
-			"application/gzipped",
-			"application/x-compress",
-			"application/x-compressed",
-			"application/x-gunzip",
-			"application/x-gzip",
-			"application/x-gzip-compressed":
-
-			gzr, err := gzip.NewReader(bytes.NewReader(body))
-			if err != nil {
-				return []UserDataPart{}, fmt.Errorf("error reading gzip: %w", err)
+			verified := *env
+			verified.trustedKeys = nil
+			verified.signed = true
+			partEnv = &verified
+		}
+
+		parts, err := mimePartToUserDataParts(partHeader, body, partEnv, depth)
+		if err != nil {
+			return []UserDataPart{}, err
+		}
+
+		opts, err := scriptOptionsFromHeader(part.Header)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing part header: %w", err)
+		}
+		for i := range parts {
+			parts[i].scriptOptions = opts.Override(parts[i].scriptOptions)
+		}
+
+		if mergeType := part.Header.Get("X-Merge-Type"); mergeType != "" {
+			for i := range parts {
+				if parts[i].mergeType == "" {
+					parts[i].mergeType = mergeType
+				}
 			}
-			body, err = ioutil.ReadAll(gzr)
-			if err != nil {
-				gzr.Close()
-				return []UserDataPart{}, fmt.Errorf("error reading gzip: %w", err)
+		}
+
+		for i := range parts {
+			parts[i].verified = env.RequiresSignatures()
+		}
+		udParts = append(udParts, parts...)
+	}
+
+	return udParts, nil
+}
+
+// mimePartToUserDataParts converts the decoded body of a MIME part into
+// UserDataParts according to its media type.
+func mimePartToUserDataParts(partHeader headerInfo, body []byte, env *Environment, depth int) ([]UserDataPart, error) {
+	udParts := []UserDataPart{}
+	switch partHeader.mediaType {
+	case "text/cloud-config":
+		part, err := payloadAsCloudConfigPart(partHeader.fileName, string(body), env)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing cloud-config: %w", err)
+		}
+
+		udParts = append(udParts, part)
+	case "text/x-shellscript":
+		part, err := payloadAsScriptPart(partHeader.fileName, string(body), env)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing script: %w", err)
+		}
+		udParts = append(udParts, part)
+	case "text/cloud-boothook":
+		part, err := payloadAsBoothookPart(partHeader.fileName, string(body), env)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing boothook: %w", err)
+		}
+		udParts = append(udParts, part)
+	case "text/cloud-config-archive":
+		payload := string(body)
+		if !config.IsCloudConfigArchive(payload) {
+			payload = "#cloud-config-archive\n" + payload
+		}
+		parts, err := archiveToUserDataParts(payload, env, depth)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing cloud-config-archive: %w", err)
+		}
+		udParts = append(udParts, parts...)
+	case "text/x-include-url", "text/x-include-once-url":
+		payload := string(body)
+		if !config.IsInclude(payload) {
+			header := "#include"
+			if partHeader.mediaType == "text/x-include-once-url" {
+				header = "#include-once"
 			}
+			payload = header + "\n" + payload
+		}
+		parts, err := includeToUserDataParts(payload, env, depth)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing include: %w", err)
+		}
+		udParts = append(udParts, parts...)
+	case "application/age", "application/x-age-encrypted":
+		decrypted, err := config.DecryptAgeContent(body)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error decrypting part %q: %w", partHeader.fileName, err)
+		}
+		// The decrypted body must never be logged and parse errors may quote
+		// it, so they are not passed on.
+		parts, err := mimePartToUserDataParts(headerInfo{fileName: partHeader.fileName}, decrypted, env, depth)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing decrypted part %q", partHeader.fileName)
+		}
+		udParts = append(udParts, parts...)
+	case "application/gzip",
+		"application/gzip-compressed",
+		"application/gzipped",
+		"application/x-compress",
+		"application/x-compressed",
+		"application/x-gunzip",
+		"application/x-gzip",
+		"application/x-gzip-compressed":
+
+		gzr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error reading gzip: %w", err)
+		}
+		body, err = ioutil.ReadAll(gzr)
+		if err != nil {
 			gzr.Close()
-			// with the gzip wrapper removed, we can now parse the part. Fallthrough to
-			// the default condition, which will attempt to detect the part type and return
-			// a UserDataPart.
-			fallthrough
-		default:
-			parsedParts, err := partsFromUserData(string(body), env)
-			if err != nil {
-				return []UserDataPart{}, fmt.Errorf("error parsing part: %w", err)
-			}
-			udParts = append(udParts, parsedParts...)
+			return []UserDataPart{}, fmt.Errorf("error reading gzip: %w", err)
+		}
+		gzr.Close()
+		// with the gzip wrapper removed, we can now parse the part. Fallthrough to
+		// the default condition, which will attempt to detect the part type and return
+		// a UserDataPart.
+		fallthrough
+	default:
+		parsedParts, err := partsFromUserData(string(body), env, depth)
+		if err != nil {
+			return []UserDataPart{}, fmt.Errorf("error parsing part: %w", err)
 		}
+		udParts = append(udParts, parsedParts...)
 	}
 
 	return udParts, nil
 }
 
+// archiveEntry is an entry of a "#cloud-config-archive" user-data. An entry
+// may also be given as a plain string, which is then its content.
+type archiveEntry struct {
+	Type     string `yaml:"type"`
+	Content  string `yaml:"content"`
+	Filename string `yaml:"filename"`
+}
+
+func (e *archiveEntry) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&e.Content)
+	}
+	type entry archiveEntry
+	return value.Decode((*entry)(e))
+}
+
+// archiveToUserDataParts converts each entry of a "#cloud-config-archive"
+// user-data into UserDataParts, handling its type like the Content-Type of a
+// MIME part. The launch-index of entries is ignored as none of the
+// datasources provide the launch index of the instance.
+func archiveToUserDataParts(payload string, env *Environment, depth int) ([]UserDataPart, error) {
+	var entries []archiveEntry
+	if err := yaml.Unmarshal([]byte(payload), &entries); err != nil {
+		return nil, err
+	}
+
+	udParts := []UserDataPart{}
+	for i, entry := range entries {
+		name := entry.Filename
+		if name == "" {
+			name = fmt.Sprintf("part-%03d", i+1)
+		}
+		var mediaType string
+		if entry.Type != "" {
+			var err error
+			if mediaType, _, err = mime.ParseMediaType(entry.Type); err != nil {
+				return nil, fmt.Errorf("entry %q: %w", name, err)
+			}
+		}
+
+		parts, err := mimePartToUserDataParts(headerInfo{mediaType: mediaType, fileName: name}, []byte(entry.Content), env, depth)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", name, err)
+		}
+		// Only multipart MIME and archives name their own parts.
+		if !config.IsMultipartMime(entry.Content) && !config.IsCloudConfigArchive(entry.Content) && !config.IsInclude(entry.Content) {
+			for i := range parts {
+				parts[i].fileName = name
+			}
+		}
+		udParts = append(udParts, parts...)
+	}
+	return udParts, nil
+}
+
 func payloadAsScriptPart(name, payload string, env *Environment) (UserDataPart, error) {
 	if env == nil {
 		return UserDataPart{}, fmt.Errorf("environment is nil")
@@ -184,6 +374,24 @@ func payloadAsScriptPart(name, payload string, env *Environment) (UserDataPart,
 	}, nil
 }
 
+func payloadAsBoothookPart(name, payload string, env *Environment) (UserDataPart, error) {
+	if env == nil {
+		return UserDataPart{}, fmt.Errorf("environment is nil")
+	}
+
+	userdata := env.Apply(payload)
+	script, err := config.NewBoothook(userdata)
+	if err != nil {
+		return UserDataPart{}, err
+	}
+	return UserDataPart{
+		userDataType: BoothookType,
+		contents:     userdata,
+		script:       script,
+		fileName:     name,
+	}, nil
+}
+
 func payloadAsCloudConfigPart(name, payload string, env *Environment) (UserDataPart, error) {
 	if env == nil {
 		return UserDataPart{}, fmt.Errorf("environment is nil")
@@ -214,7 +422,7 @@ func payloadAsCloudConfigPart(name, payload string, env *Environment) (UserDataP
 	}, nil
 }
 
-func partsFromUserData(payload string, env *Environment) ([]UserDataPart, error) {
+func partsFromUserData(payload string, env *Environment, depth int) ([]UserDataPart, error) {
 	if env == nil {
 		return nil, fmt.Errorf("environment is nil")
 	}
@@ -227,23 +435,45 @@ func partsFromUserData(payload string, env *Environment) ([]UserDataPart, error)
 			return nil, fmt.Errorf("error parsing script: %w", err)
 		}
 		parts = append(parts, part)
+	case config.IsBoothook(payload):
+		part, err := payloadAsBoothookPart("boothook.sh", payload, env)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing boothook: %w", err)
+		}
+		parts = append(parts, part)
 	case config.IsCloudConfig(payload):
 		part, err := payloadAsCloudConfigPart("cloud-config.yaml", payload, env)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing cloud-config: %w", err)
 		}
 		parts = append(parts, part)
+	case config.IsCloudConfigArchive(payload):
+		udParts, err := archiveToUserDataParts(payload, env, depth)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cloud-config-archive: %w", err)
+		}
+		parts = append(parts, udParts...)
+	case config.IsInclude(payload):
+		udParts, err := includeToUserDataParts(payload, env, depth)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing include: %w", err)
+		}
+		parts = append(parts, udParts...)
 	case config.IsIgnitionConfig(payload):
-		// we don't actually do anything with it, but we add it as a part
-		// and log a warning later.
+		// Configs which can't be parsed fail when the part is run.
+		ign, err := config.NewIgnition(payload)
+		if err != nil && !errors.Is(err, config.ErrLegacyIgnition) {
+			log.Printf("Failed parsing Ignition config: %v", err)
+		}
 		part := UserDataPart{
 			userDataType: IgnitionType,
 			contents:     payload,
 			fileName:     "ignition.json",
+			ignition:     ign,
 		}
 		parts = append(parts, part)
 	case config.IsMultipartMime(payload):
-		udParts, err := multipartToUserDataParts(payload, env)
+		udParts, err := multipartToUserDataParts(payload, env, depth)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing multipart MIME: %w", err)
 		}
@@ -264,8 +494,23 @@ type UserDataPart struct {
 	contents     string
 	fileName     string
 
+	// index is the position of the part in the user-data, starting at 1.
+	index int
+
+	// verified is set once the signature covering the part has been checked
+	// against the trusted keys. signatureErr holds the reason it wasn't.
+	verified     bool
+	signatureErr error
+
+	// mergeType is the X-Merge-Type header of the MIME part.
+	mergeType string
+
+	// scriptOptions configure the unit running a script part.
+	scriptOptions config.ScriptOptions
+
 	cloudConfig *config.CloudConfig
 	script      *config.Script
+	ignition    *config.Ignition
 }
 
 func (udp *UserDataPart) PartType() UserDataType {
@@ -284,6 +529,10 @@ func (udp *UserDataPart) IsScript() bool {
 	return udp.userDataType == ScriptType
 }
 
+func (udp *UserDataPart) IsBoothook() bool {
+	return udp.userDataType == BoothookType
+}
+
 func (udp *UserDataPart) IsIgnition() bool {
 	return udp.userDataType == IgnitionType
 }
@@ -303,14 +552,86 @@ func (udp *UserDataPart) runScript(env *Environment) error {
 		return err
 	}
 	path, err := PersistScriptInWorkspace(*udp.script, env.Workspace())
+	if err != nil {
+		return err
+	}
+
+	opts := env.scriptOptions(udp.scriptOptions, env.waitForScripts)
+	run := ScriptRun{Start: time.Now()}
+	if env.waitForScripts {
+		var status int32
+		run.Unit, status, err = system.ExecuteScriptAndWait(path, opts)
+		run.finish(status)
+		persistScriptRun(udp.runName(), run, err, env)
+		return err
+	}
+
+	// The script isn't waited for, so its unit records the end of the run
+	// itself.
+	run.Unit = system.ScriptUnitName(path)
+	var onStop []string
+	runDir, err := PersistScriptRunInWorkspace(udp.runName(), run, nil, env.Workspace())
 	if err == nil {
-		var name string
-		name, err = system.ExecuteScript(path)
-		PersistUnitNameInWorkspace(name, env.Workspace())
+		onStop, err = finishScriptRunCommand(runDir)
+	}
+	if err != nil {
+		log.Printf("Failed recording the run of script %q: %v", udp.fileName, err)
+	}
+	if _, err = system.ExecuteScript(path, opts, onStop); err != nil && onStop != nil {
+		run.Error = err.Error()
+		if perr := writeScriptRun(runDir, run, nil); perr != nil {
+			log.Printf("Failed recording the run of script %q: %v", udp.fileName, perr)
+		}
 	}
 	return err
 }
 
+// runName names the records of the runs of a script part after its position
+// in the user-data and its file name, so that parts of the same name don't
+// share them.
+func (udp *UserDataPart) runName() string {
+	return fmt.Sprintf("%03d-%s", udp.index, udp.fileName)
+}
+
+// finishScriptRunCommand returns the command which the unit of a script
+// runs once it has exited to record the end of the run in runDir.
+func finishScriptRunCommand(runDir string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return []string{exe, "finish-script-run", runDir}, nil
+}
+
+// finish records the end of a run which was waited for, with the exit
+// status of its unit if it is known.
+func (run *ScriptRun) finish(status int32) {
+	end := time.Now()
+	run.End = &end
+	if status >= 0 {
+		run.ExitStatus = &status
+	}
+}
+
+// persistScriptRun records the run of a script or command in the workspace,
+// with the output of its unit if it was waited for. Failures are only
+// logged.
+func persistScriptRun(part string, run ScriptRun, err error, env *Environment) {
+	var output []byte
+	if run.End != nil && run.Unit != "" {
+		var oerr error
+		if output, oerr = system.UnitOutput(run.Unit, 200); oerr != nil {
+			log.Printf("Failed reading the output of unit %s: %v", run.Unit, oerr)
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if _, perr := PersistScriptRunInWorkspace(part, run, output, env.Workspace()); perr != nil {
+		log.Printf("Failed recording the run of script %q: %v", part, perr)
+	}
+}
+
 func (udp *UserDataPart) runCloudConfig(env *Environment) error {
 	if err := Apply(*udp.cloudConfig, env); err != nil {
 		return fmt.Errorf("error applying cloud-config: %w", err)
@@ -324,6 +645,10 @@ func (udp *UserDataPart) RunPart(env *Environment) error {
 		return udp.runScript(env)
 	case CloudConfigType:
 		return udp.runCloudConfig(env)
+	case IgnitionType:
+		return udp.runIgnition(env)
+	case BoothookType:
+		// Boothooks have already been run by RunBoothooks.
 	default:
 		log.Printf("ignoring part of type %s", udp.userDataType)
 	}
@@ -333,6 +658,14 @@ func (udp *UserDataPart) RunPart(env *Environment) error {
 type UserData struct {
 	Parts []UserDataPart
 
+	// Rejected lists the parts dropped because they were not signed by a
+	// trusted key.
+	Rejected []error
+
+	// scriptDefaults are the options given by the "scripts" sections of
+	// the cloud-configs.
+	scriptDefaults config.ScriptOptions
+
 	env *Environment
 }
 
@@ -345,6 +678,27 @@ func (ud *UserData) FindHostname() string {
 	return ""
 }
 
+// FindFQDN returns the fqdn of the first cloud-config which has one.
+func (ud *UserData) FindFQDN() string {
+	for _, part := range ud.Parts {
+		if part.cloudConfig != nil && part.cloudConfig.FQDN != "" {
+			return part.cloudConfig.FQDN
+		}
+	}
+	return ""
+}
+
+// FindPreferFQDN returns whether any cloud-config prefers the FQDN as
+// hostname.
+func (ud *UserData) FindPreferFQDN() bool {
+	for _, part := range ud.Parts {
+		if part.cloudConfig != nil && part.cloudConfig.PreferFQDN {
+			return true
+		}
+	}
+	return false
+}
+
 func (ud *UserData) FindSSHKeys(additionalKeys []string) []string {
 	keys := make(map[string]struct{})
 
@@ -368,6 +722,15 @@ func (ud *UserData) FindSSHKeys(additionalKeys []string) []string {
 				}
 			}
 		}
+		if part.ignition != nil {
+			for _, user := range part.ignition.Passwd.Users {
+				if user.Name == "core" {
+					for _, key := range user.SSHAuthorizedKeys {
+						keys[key] = struct{}{}
+					}
+				}
+			}
+		}
 	}
 	ret := []string{}
 	for key := range keys {
@@ -376,6 +739,40 @@ func (ud *UserData) FindSSHKeys(additionalKeys []string) []string {
 	return append(ret, additionalKeys...)
 }
 
+// scriptOptionsFromHeader reads the options of a script from the X-Script-*
+// headers of its MIME part.
+func scriptOptionsFromHeader(header textproto.MIMEHeader) (config.ScriptOptions, error) {
+	opts := config.ScriptOptions{
+		Interpreter:      header.Get("X-Script-Interpreter"),
+		Environment:      header.Values("X-Script-Environment"),
+		User:             header.Get("X-Script-User"),
+		WorkingDirectory: header.Get("X-Script-Working-Directory"),
+		Timeout:          header.Get("X-Script-Timeout"),
+		ProtectSystem:    header.Get("X-Script-Protect-System"),
+		ProtectHome:      header.Get("X-Script-Protect-Home"),
+	}
+	for _, b := range []struct {
+		header string
+		value  *bool
+	}{
+		{"X-Script-Private-Tmp", &opts.PrivateTmp},
+		{"X-Script-Private-Network", &opts.PrivateNetwork},
+		{"X-Script-Private-Devices", &opts.PrivateDevices},
+		{"X-Script-No-New-Privileges", &opts.NoNewPrivileges},
+	} {
+		if v := header.Get(b.header); v != "" {
+			var err error
+			if *b.value, err = strconv.ParseBool(v); err != nil {
+				return config.ScriptOptions{}, fmt.Errorf("invalid %s header %q", b.header, v)
+			}
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return config.ScriptOptions{}, err
+	}
+	return opts, nil
+}
+
 type headerGetter interface {
 	Get(key string) string
 }
@@ -15,9 +15,25 @@
 package initialize
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"os"
+	"path"
+	"strings"
 	"testing"
 
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/flatcar/coreos-cloudinit/config"
 	"github.com/flatcar/coreos-cloudinit/datasource"
 
 	"github.com/stretchr/testify/require"
@@ -103,6 +119,25 @@ func TestNewUserDataParsesScript(t *testing.T) {
 	require.NotNil(t, udata.Parts[0].script)
 }
 
+func TestNewUserDataParsesCloudConfigArchive(t *testing.T) {
+	data, err := os.ReadFile("testdata/cloudconfig_archive_userdata.txt")
+	require.NoError(t, err)
+
+	udata, err := NewUserData(string(data), getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(udata.Parts))
+	require.Equal(t, CloudConfigType, udata.Parts[0].userDataType)
+	require.Equal(t, "hostname.yaml", udata.Parts[0].PartName())
+	require.Equal(t, "archived", udata.FindHostname())
+	require.Equal(t, ScriptType, udata.Parts[1].userDataType)
+	require.Equal(t, "part-002", udata.Parts[1].PartName())
+	require.Equal(t, ScriptType, udata.Parts[2].userDataType)
+	require.Equal(t, "compressed.sh", udata.Parts[2].PartName())
+	require.Equal(t, "#!/bin/bash\necho compressed\n", udata.Parts[2].contents)
+	require.Equal(t, ScriptType, udata.Parts[3].userDataType)
+	require.Equal(t, "part-004", udata.Parts[3].PartName())
+}
+
 func TestNewUserDataParsesUnknown(t *testing.T) {
 	data, err := os.ReadFile("testdata/unknown_userdata.txt")
 	require.NoError(t, err)
@@ -112,3 +147,326 @@ func TestNewUserDataParsesUnknown(t *testing.T) {
 	require.Equal(t, 1, len(udata.Parts))
 	require.Equal(t, udata.Parts[0].userDataType, UnknownType)
 }
+
+type testPart struct {
+	name        string
+	contentType string
+	body        string
+	sign        bool
+}
+
+// signedMultipart builds a multipart MIME user-data from the given parts,
+// signing those with sign set.
+func signedMultipart(t *testing.T, key ed25519.PrivateKey, parts []testPart) string {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.name))
+		if p.sign {
+			header.Set(config.SignatureHeader, "ed25519="+base64.StdEncoding.EncodeToString(ed25519.Sign(key, config.SignedData(header, []byte(p.body)))))
+		}
+		pw, err := w.CreatePart(header)
+		require.NoError(t, err)
+		pw.Write([]byte(p.body))
+	}
+	require.NoError(t, w.Close())
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n%s", w.Boundary(), buf.String())
+}
+
+func TestNewUserDataRequiresSignatures(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	payload := signedMultipart(t, priv, []testPart{
+		{"signed.yaml", "text/cloud-config", "#cloud-config\nhostname: signed\n", true},
+		{"unsigned.yaml", "text/cloud-config", "#cloud-config\nhostname: unsigned\n", false},
+		{"signed.sh", "text/x-shellscript", "#!/bin/bash\necho signed\n", true},
+	})
+
+	// Without a policy, every part is accepted.
+	udata, err := NewUserData(payload, getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(udata.Parts))
+	require.Empty(t, udata.Rejected)
+
+	env := getTestEnv()
+	env.RequireSignatures(config.TrustedKeys{pub})
+	udata, err = NewUserData(payload, env)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(udata.Parts))
+	require.Equal(t, "signed.yaml", udata.Parts[0].PartName())
+	require.Equal(t, "signed.sh", udata.Parts[1].PartName())
+	require.Equal(t, 1, len(udata.Rejected))
+	require.ErrorIs(t, udata.Rejected[0], config.ErrUnsigned)
+	require.Equal(t, "signed", udata.FindHostname())
+
+	// Plain user-data can't carry a signature.
+	udata, err = NewUserData("#cloud-config\nhostname: plain\n", env)
+	require.NoError(t, err)
+	require.Empty(t, udata.Parts)
+	require.Equal(t, 1, len(udata.Rejected))
+}
+
+func TestNewUserDataVerifiesSignaturesFirst(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte("#cloud-config\nhostname: included\n"))
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	env := NewEnvironment(root, "./", "/var/lib/coreos-cloudinit", "", datasource.Metadata{InstanceID: "i-1"})
+	env.RequireSignatures(config.TrustedKeys{pub})
+
+	// Unsigned includes are neither fetched nor cached.
+	for _, payload := range []string{
+		signedMultipart(t, priv, []testPart{{"include.txt", "text/x-include-once-url", server.URL + "/config\n", false}}),
+		"#include-once\n" + server.URL + "/config\n",
+	} {
+		udata, err := NewUserData(payload, env)
+		require.NoError(t, err)
+		require.Empty(t, udata.Parts)
+		require.Equal(t, 1, len(udata.Rejected))
+		require.False(t, fetched)
+		require.NoDirExists(t, path.Join(env.Workspace(), "include-once"))
+	}
+
+	digest := fmt.Sprintf("#sha512=%x", sha512.Sum512([]byte("#cloud-config\nhostname: included\n")))
+	udata, err := NewUserData(signedMultipart(t, priv, []testPart{{"include.txt", "text/x-include-once-url", server.URL + "/config" + digest + "\n", true}}), env)
+	require.NoError(t, err)
+	require.Empty(t, udata.Rejected)
+	require.Equal(t, "included", udata.FindHostname())
+}
+
+func TestNewUserDataRequiresPinnedIncludes(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	contents := map[string]string{
+		"/config": "#cloud-config\nhostname: included\n",
+	}
+	digest := func(name string) string {
+		return fmt.Sprintf("#sha512=%x", sha512.Sum512([]byte(contents[name])))
+	}
+	fetched := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched[r.URL.Path] = true
+		w.Write([]byte(contents[r.URL.Path]))
+	}))
+	defer server.Close()
+	contents["/pinned"] = "#include\n" + server.URL + "/config" + digest("/config") + "\n"
+	contents["/unpinned"] = "#include\n" + server.URL + "/config\n"
+
+	env := getTestEnv()
+	env.RequireSignatures(config.TrustedKeys{pub})
+
+	// The signature only covers the URLs, so what they point to must be
+	// pinned, including by included user-data.
+	for _, tt := range []struct {
+		contentType string
+		body        string
+		err         bool
+	}{
+		{"text/x-include-url", server.URL + "/config\n", true},
+		{"text/plain", "#include\n" + server.URL + "/config\n", true},
+		{"text/x-include-url", server.URL + "/unpinned" + digest("/unpinned") + "\n", true},
+		{"text/x-include-url", server.URL + "/config#sha512=" + strings.Repeat("0", 128) + "\n", true},
+		{"text/x-include-url", server.URL + "/config" + digest("/config") + "\n", false},
+		{"text/plain", "#include\n" + server.URL + "/pinned" + digest("/pinned") + "\n", false},
+	} {
+		fetched = map[string]bool{}
+		udata, err := NewUserData(signedMultipart(t, priv, []testPart{{"include.txt", tt.contentType, tt.body, true}}), env)
+		if tt.err {
+			// Unpinned URLs aren't even fetched.
+			require.Error(t, err, tt.body)
+			require.Equal(t, strings.Contains(tt.body, "/config#"), fetched["/config"], tt.body)
+			continue
+		}
+		require.NoError(t, err, tt.body)
+		require.Equal(t, "included", udata.FindHostname())
+	}
+}
+
+func TestNewUserDataDecryptsAgeParts(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	key := path.Join(t.TempDir(), "age.key")
+	require.NoError(t, os.WriteFile(key, []byte(identity.String()+"\n"), 0600))
+
+	buf := &bytes.Buffer{}
+	a := armor.NewWriter(buf)
+	w, err := age.Encrypt(a, identity.Recipient())
+	require.NoError(t, err)
+	io.WriteString(w, "#cloud-config\nhostname: secret\n")
+	require.NoError(t, w.Close())
+	require.NoError(t, a.Close())
+
+	payload := signedMultipart(t, nil, []testPart{
+		{"secret.age", "application/x-age-encrypted", buf.String(), false},
+	})
+
+	files := config.AgeKeyFiles
+	defer func() { config.AgeKeyFiles = files }()
+
+	config.AgeKeyFiles = []string{path.Join(t.TempDir(), "missing.key")}
+	_, err = NewUserData(payload, getTestEnv())
+	require.ErrorIs(t, err, config.ErrNoAgeKey)
+
+	config.AgeKeyFiles = []string{key}
+	udata, err := NewUserData(payload, getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(udata.Parts))
+	require.True(t, udata.Parts[0].IsCloudConfig())
+	require.Equal(t, "secret", udata.FindHostname())
+}
+
+func TestMergeCloudConfigs(t *testing.T) {
+	payload := `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/cloud-config
+
+#cloud-config
+hostname: first
+users:
+  - name: core
+    ssh_authorized_keys: [first]
+write_files:
+  - path: /etc/motd
+    permissions: 0644
+    content: first
+--BOUNDARY
+Content-Type: text/x-shellscript
+
+#!/bin/bash
+echo script
+--BOUNDARY
+Content-Type: text/cloud-config
+X-Merge-Type: list(append)+dict(recurse_array)+str()
+
+#cloud-config
+users:
+  - name: core
+    ssh_authorized_keys: [second]
+  - name: other
+--BOUNDARY
+Content-Type: text/cloud-config
+
+#cloud-config
+merge_how: dict(no_replace)
+hostname: third
+--BOUNDARY--
+`
+	udata, err := NewUserData(payload, getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(udata.Parts))
+	require.Equal(t, "third", udata.Parts[3].cloudConfig.Hostname)
+
+	require.NoError(t, udata.MergeCloudConfigs())
+	require.Equal(t, 2, len(udata.Parts))
+	require.True(t, udata.Parts[0].IsCloudConfig())
+	require.True(t, udata.Parts[1].IsScript())
+
+	cc := udata.Parts[0].cloudConfig
+	require.Equal(t, "first", cc.Hostname)
+	require.Equal(t, 2, len(cc.Users))
+	require.Equal(t, []string{"second"}, cc.Users[0].SSHAuthorizedKeys)
+	require.Equal(t, "other", cc.Users[1].Name)
+	require.Equal(t, 1, len(cc.WriteFiles))
+	require.Equal(t, "0644", cc.WriteFiles[0].RawFilePermissions)
+	require.True(t, config.IsCloudConfig(udata.Parts[0].contents))
+}
+
+func TestNewUserDataScriptOptions(t *testing.T) {
+	payload := `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/x-shellscript
+X-Script-User: core
+X-Script-Environment: A=1
+X-Script-Environment: B=$private_ipv4
+X-Script-Private-Tmp: true
+
+#!/bin/bash
+echo first
+--BOUNDARY
+Content-Type: text/x-shellscript
+
+#!/bin/bash
+echo second
+--BOUNDARY
+Content-Type: text/cloud-config
+
+#cloud-config
+scripts:
+  user: nobody
+  protect_system: strict
+  environment: [C=3]
+--BOUNDARY--
+`
+	udata, err := NewUserData(payload, getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(udata.Parts))
+
+	require.Equal(t, config.ScriptOptions{
+		User:          "core",
+		Environment:   []string{"C=3", "A=1", "B=$private_ipv4"},
+		ProtectSystem: "strict",
+		PrivateTmp:    true,
+	}, udata.Parts[0].scriptOptions)
+	require.Equal(t, config.ScriptOptions{
+		User:          "nobody",
+		Environment:   []string{"C=3"},
+		ProtectSystem: "strict",
+	}, udata.Parts[1].scriptOptions)
+
+	_, err = NewUserData(strings.Replace(payload, "X-Script-Private-Tmp: true", "X-Script-Private-Tmp: maybe", 1), getTestEnv())
+	require.Error(t, err)
+}
+
+func TestNewUserDataSignatureCoversHeaders(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	env := getTestEnv()
+	env.RequireSignatures(config.TrustedKeys{pub})
+
+	payload := signedMultipart(t, priv, []testPart{{"signed.sh", "text/x-shellscript", "#!/bin/bash\necho signed\n", true}})
+	udata, err := NewUserData(payload, env)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(udata.Parts))
+	require.Empty(t, udata.Rejected)
+
+	// Neither the options nor the type of a signed part can be changed.
+	for _, header := range []string{
+		"X-Script-Interpreter: /bin/sh -c id",
+		"X-Script-Environment: LD_PRELOAD=/tmp/evil.so",
+		"X-Merge-Type: list(append)",
+	} {
+		udata, err := NewUserData(strings.Replace(payload, "Content-Type: text/x-shellscript", "Content-Type: text/x-shellscript\r\n"+header, 1), env)
+		require.NoError(t, err)
+		require.Empty(t, udata.Parts, header)
+		require.Equal(t, 1, len(udata.Rejected), header)
+	}
+	udata, err = NewUserData(strings.Replace(payload, "Content-Type: text/x-shellscript", "Content-Type: text/plain", 1), env)
+	require.NoError(t, err)
+	require.Empty(t, udata.Parts)
+	require.Equal(t, 1, len(udata.Rejected))
+}
+
+func TestUserDataPartRunName(t *testing.T) {
+	payload := signedMultipart(t, nil, []testPart{
+		{"userdata.sh", "text/x-shellscript", "#!/bin/bash\necho first\n", false},
+		{"userdata.sh", "text/x-shellscript", "#!/bin/bash\necho second\n", false},
+	})
+	udata, err := NewUserData(payload, getTestEnv())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(udata.Parts))
+	require.Equal(t, "001-userdata.sh", udata.Parts[0].runName())
+	require.Equal(t, "002-userdata.sh", udata.Parts[1].runName())
+}
@@ -15,9 +15,16 @@
 package initialize
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 	"github.com/flatcar/coreos-cloudinit/system"
@@ -64,3 +71,138 @@ func PersistUnitNameInWorkspace(name string, workspace string) error {
 	_, err := system.WriteFile(&file, workspace)
 	return err
 }
+
+// MaxScriptOutput bounds the size of the output kept for each script run.
+const MaxScriptOutput = 16 * 1024
+
+// MaxScriptRuns is the number of runs kept for each script part.
+const MaxScriptRuns = 10
+
+// ScriptRun records a run of a user-data script. End and ExitStatus are only
+// known when the script has exited, which the units of the scripts which
+// aren't waited for record themselves with FinishScriptRun.
+type ScriptRun struct {
+	Unit       string     `json:"unit"`
+	Start      time.Time  `json:"start"`
+	End        *time.Time `json:"end,omitempty"`
+	ExitStatus *int32     `json:"exit_status,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// PersistScriptRunInWorkspace records a run of the script of the given part,
+// and the tail of its output, in a new directory under
+// scripts/<part>/ in the workspace, and returns the directory. Only the
+// latest MaxScriptRuns runs of each part are kept. The name of the unit is
+// also written to scripts/unit-name, as before.
+func PersistScriptRunInWorkspace(part string, run ScriptRun, output []byte, workspace string) (string, error) {
+	if err := PersistUnitNameInWorkspace(run.Unit, workspace); err != nil {
+		return "", err
+	}
+
+	partDir := path.Join(workspace, "scripts", scriptPartDir(part))
+	if err := system.EnsureDirectoryExists(partDir); err != nil {
+		return "", err
+	}
+	runName := run.Start.UTC().Format("20060102T150405.000000000Z")
+	runDir := path.Join(partDir, runName)
+	for i := 1; ; i++ {
+		err := os.Mkdir(runDir, 0755)
+		if err == nil {
+			break
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+		runDir = path.Join(partDir, fmt.Sprintf("%s-%d", runName, i))
+	}
+
+	if err := writeScriptRun(runDir, run, output); err != nil {
+		return "", err
+	}
+	return runDir, pruneScriptRuns(partDir, MaxScriptRuns)
+}
+
+// FinishScriptRun records the end of the run recorded in runDir, given the
+// $SERVICE_RESULT, $EXIT_CODE and $EXIT_STATUS systemd passes to the
+// ExecStopPost= commands of its unit, along with the tail of its output.
+func FinishScriptRun(runDir, result, code, status string) error {
+	data, err := ioutil.ReadFile(path.Join(runDir, "status.json"))
+	if err != nil {
+		return err
+	}
+	var run ScriptRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	run.End = &end
+	if s, err := strconv.ParseInt(status, 10, 32); code == "exited" && err == nil {
+		exitStatus := int32(s)
+		run.ExitStatus = &exitStatus
+	}
+	if result != "success" {
+		run.Error = fmt.Sprintf("unit %s failed (result %q)", run.Unit, result)
+	}
+
+	output, err := system.UnitOutput(run.Unit, 200)
+	if err != nil {
+		log.Printf("Failed reading the output of unit %s: %v", run.Unit, err)
+	}
+	return writeScriptRun(runDir, run, output)
+}
+
+// writeScriptRun writes the status of the run, and the tail of its output,
+// to its directory.
+func writeScriptRun(runDir string, run ScriptRun, output []byte) error {
+	status, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(runDir, "status.json"), append(status, '\n'), 0644); err != nil {
+		return err
+	}
+	if len(output) > MaxScriptOutput {
+		output = output[len(output)-MaxScriptOutput:]
+	}
+	return ioutil.WriteFile(path.Join(runDir, "output"), output, 0644)
+}
+
+// scriptPartDir turns the name of a user-data part into a directory name.
+func scriptPartDir(part string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, part)
+	switch {
+	case strings.Trim(name, ".") == "":
+		return "user-data"
+	case name == "unit-name":
+		// Don't clash with the file written by PersistUnitNameInWorkspace.
+		return name + "_"
+	}
+	return name
+}
+
+func pruneScriptRuns(partDir string, keep int) error {
+	entries, err := ioutil.ReadDir(partDir)
+	if err != nil {
+		return err
+	}
+	var runs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			runs = append(runs, e.Name())
+		}
+	}
+	sort.Strings(runs)
+	for len(runs) > keep {
+		if err := os.RemoveAll(path.Join(partDir, runs[0])); err != nil {
+			return err
+		}
+		runs = runs[1:]
+	}
+	return nil
+}
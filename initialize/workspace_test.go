@@ -0,0 +1,107 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistScriptRunInWorkspace(t *testing.T) {
+	workspace := t.TempDir()
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < MaxScriptRuns+2; i++ {
+		end := start.Add(time.Second)
+		status := int32(i)
+		run := ScriptRun{Unit: "run-1.service", Start: start, End: &end, ExitStatus: &status}
+		_, err := PersistScriptRunInWorkspace("setup/../db.sh", run, bytes.Repeat([]byte("x"), MaxScriptOutput+10), workspace)
+		require.NoError(t, err)
+		start = start.Add(time.Minute)
+	}
+
+	name, err := os.ReadFile(path.Join(workspace, "scripts", "unit-name"))
+	require.NoError(t, err)
+	require.Equal(t, "run-1.service", string(name))
+
+	partDir := path.Join(workspace, "scripts", "setup_.._db.sh")
+	runs, err := os.ReadDir(partDir)
+	require.NoError(t, err)
+	require.Equal(t, MaxScriptRuns, len(runs))
+	require.Equal(t, "20261019T120200.000000000Z", runs[0].Name())
+
+	status, err := os.ReadFile(path.Join(partDir, runs[len(runs)-1].Name(), "status.json"))
+	require.NoError(t, err)
+	var run ScriptRun
+	require.NoError(t, json.Unmarshal(status, &run))
+	require.Equal(t, "run-1.service", run.Unit)
+	require.NotNil(t, run.ExitStatus)
+	require.Equal(t, int32(MaxScriptRuns+1), *run.ExitStatus)
+
+	output, err := os.ReadFile(path.Join(partDir, runs[0].Name(), "output"))
+	require.NoError(t, err)
+	require.Equal(t, MaxScriptOutput, len(output))
+}
+
+func TestPersistScriptRunInWorkspaceSameStart(t *testing.T) {
+	workspace := t.TempDir()
+	run := ScriptRun{Unit: "run-2.service", Start: time.Now()}
+	first, err := PersistScriptRunInWorkspace("", run, nil, workspace)
+	require.NoError(t, err)
+	second, err := PersistScriptRunInWorkspace("", run, nil, workspace)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	runs, err := os.ReadDir(path.Join(workspace, "scripts", "user-data"))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(runs))
+}
+
+func TestFinishScriptRun(t *testing.T) {
+	for _, tt := range []struct {
+		result, code, status string
+
+		exitStatus *int32
+		err        string
+	}{
+		{"success", "exited", "0", new(int32), ""},
+		{"exit-code", "exited", "3", func() *int32 { s := int32(3); return &s }(), `unit run-3.service failed (result "exit-code")`},
+		{"timeout", "killed", "TERM", nil, `unit run-3.service failed (result "timeout")`},
+	} {
+		workspace := t.TempDir()
+		start := time.Now()
+		runDir, err := PersistScriptRunInWorkspace("001-userdata.sh", ScriptRun{Unit: "run-3.service", Start: start}, nil, workspace)
+		require.NoError(t, err)
+		require.NoError(t, FinishScriptRun(runDir, tt.result, tt.code, tt.status))
+
+		status, err := os.ReadFile(path.Join(runDir, "status.json"))
+		require.NoError(t, err)
+		var run ScriptRun
+		require.NoError(t, json.Unmarshal(status, &run))
+		require.Equal(t, "run-3.service", run.Unit)
+		require.True(t, run.Start.Equal(start))
+		require.NotNil(t, run.End)
+		require.Equal(t, tt.exitStatus, run.ExitStatus)
+		require.Equal(t, tt.err, run.Error)
+	}
+
+	require.Error(t, FinishScriptRun(path.Join(t.TempDir(), "missing"), "success", "exited", "0"))
+}
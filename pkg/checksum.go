@@ -0,0 +1,109 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode"
+)
+
+// ErrChecksum is returned when fetched data does not match its expected
+// digest.
+type ErrChecksum struct {
+	Err
+}
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// minChecksumLength is the length of the shortest digest isChecksum
+// recognizes, that of a base64 encoded 128 bit digest without padding.
+const minChecksumLength = 22
+
+// Checksum is the expected digest of some data.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// ParseChecksum parses a digest of the form "<algorithm>=<hex>" or, as used
+// by Ignition, "<algorithm>-<hex>". The supported algorithms are sha256 and
+// sha512.
+func ParseChecksum(s string) (Checksum, error) {
+	i := strings.IndexAny(s, "=-")
+	if i < 0 {
+		return Checksum{}, fmt.Errorf("invalid checksum %q (want <algorithm>=<hex>)", s)
+	}
+
+	algorithm := strings.ToLower(s[:i])
+	h, ok := checksumHashes[algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	sum, err := hex.DecodeString(s[i+1:])
+	if err != nil || len(sum) != h().Size() {
+		return Checksum{}, fmt.Errorf("invalid %s checksum %q", algorithm, s[i+1:])
+	}
+	return Checksum{Algorithm: algorithm, Sum: sum}, nil
+}
+
+// isChecksum reports whether s is meant as a digest: either its algorithm is
+// supported or it is shaped like "<algorithm>=<hex>" or
+// "<algorithm>-<base64>", such as "md5=<hex>". Fragments such as "#section-2"
+// are not.
+func isChecksum(s string) bool {
+	i := strings.IndexAny(s, "=-")
+	if i <= 0 {
+		return false
+	}
+	if _, ok := checksumHashes[strings.ToLower(s[:i])]; ok {
+		return true
+	}
+	if len(s)-i-1 < minChecksumLength {
+		return false
+	}
+	for _, c := range s[:i] {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	digits := "0123456789abcdefABCDEF"
+	if s[i] == '-' {
+		digits += "ghijklmnopqrstuvwxyzGHIJKLMNOPQRSTUVWXYZ+/_-="
+	}
+	return strings.Trim(s[i+1:], digits) == ""
+}
+
+// Verify returns an ErrChecksum if the digest of data doesn't match.
+func (c Checksum) Verify(data []byte) error {
+	h := checksumHashes[c.Algorithm]()
+	h.Write(data)
+	if sum := h.Sum(nil); !bytes.Equal(sum, c.Sum) {
+		return ErrChecksum{fmt.Errorf("%s checksum mismatch: want %x, got %x", c.Algorithm, c.Sum, sum)}
+	}
+	return nil
+}
+
+func (c Checksum) String() string {
+	return fmt.Sprintf("%s=%x", c.Algorithm, c.Sum)
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+)
+
+const (
+	emptySHA512 = "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"
+)
+
+func TestParseChecksum(t *testing.T) {
+	for _, tt := range []struct {
+		in string
+
+		algorithm string
+		err       bool
+	}{
+		{"sha512=" + emptySHA512, "sha512", false},
+		{"sha512-" + emptySHA512, "sha512", false},
+		{"SHA512=" + emptySHA512, "sha512", false},
+		{"sha256=" + emptyPayloadHash, "sha256", false},
+		{"sha256=" + emptySHA512, "", true},
+		{"sha512=xyz", "", true},
+		{"md5=d41d8cd98f00b204e9800998ecf8427e", "", true},
+		{emptySHA512, "", true},
+	} {
+		c, err := ParseChecksum(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (%q): want %t, got %v", tt.in, tt.err, err)
+		}
+		if c.Algorithm != tt.algorithm {
+			t.Errorf("bad algorithm (%q): want %q, got %q", tt.in, tt.algorithm, c.Algorithm)
+		}
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+	c, err := ParseChecksum("sha512=" + emptySHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify([]byte{}); err != nil {
+		t.Errorf("bad error: want nil, got %v", err)
+	}
+	if err := c.Verify([]byte("#cloud-config")); err == nil {
+		t.Errorf("bad error: want ErrChecksum, got nil")
+	} else if _, ok := err.(ErrChecksum); !ok {
+		t.Errorf("bad error: want ErrChecksum, got %#v", err)
+	}
+}
+
+func TestFetchChecksum(t *testing.T) {
+	client := NewHttpClient()
+	for _, tt := range []struct {
+		url string
+		err bool
+	}{
+		{"data:,#sha512=" + emptySHA512, false},
+		{"data:,#sha256=" + emptyPayloadHash, false},
+		{"data:,hello#sha512=" + emptySHA512, true},
+		{"data:,#sha512=d41d8cd98f00b204e9800998ecf8427e", true},
+		{"data:,#md5=d41d8cd98f00b204e9800998ecf8427e", true},
+		{"data:,#sha224-0UoCjCo6K8lHYQK7KII0xBWisB+CjqYqxbPkLw==", true},
+		{"data:,#sha512=xyz", true},
+		{"data:,#section", false},
+		{"data:,#section-2", false},
+		{"data:,#a=b", false},
+	} {
+		if _, err := client.Fetch(tt.url); (err != nil) != tt.err {
+			t.Errorf("bad error (%q): want %t, got %v", tt.url, tt.err, err)
+		}
+	}
+}
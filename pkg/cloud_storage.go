@@ -0,0 +1,208 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ec2MetadataAddress and gceMetadataAddress are the instance metadata
+	// services providing the credentials of the instance role.
+	ec2MetadataAddress = "http://169.254.169.254/latest/"
+	gceMetadataAddress = "http://metadata.google.internal/computeMetadata/v1/"
+
+	// s3Address and gcsAddress build the address of an object in a bucket.
+	s3Address = func(bucket, region string) string {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", bucket, region)
+	}
+	gcsAddress = "https://storage.googleapis.com/storage/v1/"
+)
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+}
+
+// withHeader returns a copy of the client sending the given headers.
+func (h *HttpClient) withHeader(header http.Header) *HttpClient {
+	c := *h
+	c.Header = header
+	return &c
+}
+
+// fetchS3 downloads an object from S3 using the credentials of the EC2
+// instance role.
+func fetchS3(h *HttpClient, url *neturl.URL) ([]byte, error) {
+	bucket, key := url.Host, strings.TrimPrefix(url.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, ErrInvalid{fmt.Errorf("S3 URL %s must be of the form s3://<bucket>/<key>", url)}
+	}
+
+	token, err := ec2MetadataToken(h)
+	if err != nil {
+		return nil, err
+	}
+	md := h.withHeader(http.Header{"X-aws-ec2-metadata-token": {token}})
+
+	region, err := md.GetRetry(ec2MetadataAddress + "meta-data/placement/region")
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine the instance region: %w", err)
+	}
+	roles, err := md.GetRetry(ec2MetadataAddress + "meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine the instance role: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	data, err := md.GetRetry(ec2MetadataAddress + "meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch credentials for role %q: %w", role, err)
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials for role %q: %w", role, err)
+	}
+
+	object := s3Address(bucket, string(region)) + (&neturl.URL{Path: key}).EscapedPath()
+	req, err := http.NewRequest("GET", object, nil)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	signV4(req, creds, string(region), "s3", time.Now())
+
+	return h.withHeader(req.Header).GetRetry(object)
+}
+
+// ec2MetadataToken requests an IMDSv2 session token.
+func ec2MetadataToken(h *HttpClient) (string, error) {
+	req, err := http.NewRequest("PUT", ec2MetadataAddress+"api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", ErrNetwork{fmt.Errorf("unable to fetch metadata token: %w", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrServer{fmt.Errorf("metadata token response status code %d", resp.StatusCode)}
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	return string(token), err
+}
+
+// signV4 signs the request with AWS Signature Version 4. The host and all
+// X-Amz-* headers are signed and the payload is assumed to be empty.
+func signV4(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", now.Format("20060102T150405Z"), scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// fetchGS downloads an object from Google Cloud Storage using the default
+// service account of the GCE instance.
+func fetchGS(h *HttpClient, url *neturl.URL) ([]byte, error) {
+	bucket, object := url.Host, strings.TrimPrefix(url.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, ErrInvalid{fmt.Errorf("GS URL %s must be of the form gs://<bucket>/<object>", url)}
+	}
+
+	md := h.withHeader(http.Header{"Metadata-Flavor": {"Google"}})
+	data, err := md.GetRetry(gceMetadataAddress + "instance/service-accounts/default/token")
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch service account token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("unable to parse service account token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("service account token is empty")
+	}
+
+	address := fmt.Sprintf("%sb/%s/o/%s?alt=media", gcsAddress, neturl.PathEscape(bucket), neturl.PathEscape(object))
+	return h.withHeader(http.Header{"Authorization": {"Bearer " + token.AccessToken}}).GetRetry(address)
+}
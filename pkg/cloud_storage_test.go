@@ -0,0 +1,118 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test against the "get-vanilla" case of the AWS Signature Version 4 test
+// suite.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := awsCredentials{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("bad signature:\nwant %s\ngot  %s", want, got)
+	}
+}
+
+func TestFetchS3(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			fmt.Fprint(w, "token")
+		case r.Header.Get("X-aws-ec2-metadata-token") != "token" && strings.HasPrefix(r.URL.Path, "/latest/"):
+			http.Error(w, "", 401)
+		case r.URL.Path == "/latest/meta-data/placement/region":
+			fmt.Fprint(w, "eu-central-1")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "cloudinit-role\n")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/cloudinit-role":
+			fmt.Fprint(w, `{"Code": "Success", "AccessKeyId": "AKID", "SecretAccessKey": "secret", "Token": "session"}`)
+		case r.URL.Path == "/bucket/path/to/user-data":
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-central-1/s3/aws4_request") ||
+				r.Header.Get("X-Amz-Security-Token") != "session" {
+				http.Error(w, "", 403)
+				return
+			}
+			fmt.Fprint(w, "#cloud-config\n")
+		default:
+			http.Error(w, "", 404)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(md string, s3 func(string, string) string) {
+		ec2MetadataAddress, s3Address = md, s3
+	}(ec2MetadataAddress, s3Address)
+	ec2MetadataAddress = ts.URL + "/latest/"
+	s3Address = func(bucket, region string) string {
+		return ts.URL + "/" + bucket + "/"
+	}
+
+	data, err := NewHttpClient().Fetch("s3://bucket/path/to/user-data")
+	if err != nil || string(data) != "#cloud-config\n" {
+		t.Errorf("bad result: want (%q, nil), got (%q, %v)", "#cloud-config\n", data, err)
+	}
+
+	if _, err := NewHttpClient().Fetch("s3://bucket"); err == nil {
+		t.Errorf("bad error: want ErrInvalid, got nil")
+	}
+}
+
+func TestFetchGS(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "", 403)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "secret", "expires_in": 3599, "token_type": "Bearer"}`)
+		case r.URL.EscapedPath() == "/storage/v1/b/bucket/o/path%2Fto%2Fuser-data" && r.URL.Query().Get("alt") == "media":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "", 403)
+				return
+			}
+			fmt.Fprint(w, "#cloud-config\n")
+		default:
+			http.Error(w, "", 404)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(md, gcs string) {
+		gceMetadataAddress, gcsAddress = md, gcs
+	}(gceMetadataAddress, gcsAddress)
+	gceMetadataAddress = ts.URL + "/computeMetadata/v1/"
+	gcsAddress = ts.URL + "/storage/v1/"
+
+	data, err := NewHttpClient().Fetch("gs://bucket/path/to/user-data")
+	if err != nil || string(data) != "#cloud-config\n" {
+		t.Errorf("bad result: want (%q, nil), got (%q, %v)", "#cloud-config\n", data, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// Fetcher retrieves the contents of a URL. The HttpClient the fetch was
+// requested through is passed along so that fetchers talking HTTP reuse its
+// configuration.
+type Fetcher func(h *HttpClient, url *neturl.URL) ([]byte, error)
+
+var fetchers = map[string]Fetcher{
+	"http":  fetchHTTP,
+	"https": fetchHTTP,
+	"data":  fetchData,
+	"file":  fetchFile,
+	"tftp":  fetchTFTP,
+	"s3":    fetchS3,
+	"gs":    fetchGS,
+}
+
+// RegisterFetcher makes f responsible for all URLs with the given scheme,
+// replacing any previously registered fetcher.
+func RegisterFetcher(scheme string, f Fetcher) {
+	fetchers[strings.ToLower(scheme)] = f
+}
+
+// IsFetchable reports whether a fetcher is registered for the scheme of the
+// given URL.
+func IsFetchable(rawurl string) bool {
+	url, err := neturl.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	_, ok := fetchers[strings.ToLower(url.Scheme)]
+	return ok
+}
+
+// HasChecksum reports whether the given URL has a digest fragment, which
+// Fetch verifies the data against.
+func HasChecksum(rawurl string) bool {
+	url, err := neturl.Parse(rawurl)
+	return err == nil && isChecksum(url.Fragment)
+}
+
+// Fetch retrieves the contents of the given URL using the fetcher registered
+// for its scheme. HTTP URLs are fetched with GetRetry. A fragment of the form
+// "#sha512=<hex>" is treated as the expected digest of the data and checked
+// with Checksum.Verify, and digests of unsupported algorithms such as
+// "#md5=<hex>" are an error. Fragments which aren't digests are left alone.
+func (h *HttpClient) Fetch(rawurl string) ([]byte, error) {
+	if rawurl == "" {
+		return nil, ErrInvalid{errors.New("URL is empty. Skipping.")}
+	}
+
+	url, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+
+	fetch, ok := fetchers[strings.ToLower(url.Scheme)]
+	if !ok {
+		return nil, ErrInvalid{fmt.Errorf("URL %s does not have a supported scheme. Skipping.", rawurl)}
+	}
+
+	if !isChecksum(url.Fragment) {
+		return fetch(h, url)
+	}
+
+	checksum, err := ParseChecksum(url.Fragment)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+	data, err := fetch(h, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := checksum.Verify(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func fetchHTTP(h *HttpClient, url *neturl.URL) ([]byte, error) {
+	u := *url
+	u.Fragment = ""
+	return h.GetRetry(u.String())
+}
+
+// fetchData decodes an RFC 2397 data URL.
+func fetchData(_ *HttpClient, url *neturl.URL) ([]byte, error) {
+	// Everything following "data:" but the fragment is part of the data,
+	// including what url.Parse took for a query.
+	raw := url.Opaque
+	if url.RawQuery != "" || url.ForceQuery {
+		raw += "?" + url.RawQuery
+	}
+	header, data, ok := strings.Cut(raw, ",")
+	if !ok {
+		return nil, ErrInvalid{errors.New("data URL is missing the ',' separator")}
+	}
+
+	if strings.HasSuffix(header, ";base64") {
+		data, err := neturl.PathUnescape(data)
+		if err != nil {
+			return nil, ErrInvalid{err}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, ErrInvalid{fmt.Errorf("unable to decode base64 data URL: %w", err)}
+		}
+		return decoded, nil
+	}
+
+	decoded, err := neturl.PathUnescape(data)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+	return []byte(decoded), nil
+}
+
+func fetchFile(_ *HttpClient, url *neturl.URL) ([]byte, error) {
+	if url.Host != "" && url.Host != "localhost" {
+		return nil, ErrInvalid{fmt.Errorf("file URL with remote host %q is not supported", url.Host)}
+	}
+
+	data, err := ioutil.ReadFile(url.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound{err}
+	}
+	return data, err
+}
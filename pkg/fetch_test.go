@@ -0,0 +1,110 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	neturl "net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFetchData(t *testing.T) {
+	tests := []struct {
+		url string
+
+		data string
+		err  bool
+	}{
+		{"data:,%23cloud-config%0Ahostname%3A%20foo", "#cloud-config\nhostname: foo", false},
+		{"data:text/plain;charset=utf-8;base64,I2Nsb3VkLWNvbmZpZwo=", "#cloud-config\n", false},
+		{"data:;base64,I2Nsb3VkLWNvbmZpZwo%3D", "#cloud-config\n", false},
+		{"data:;base64,not base64", "", true},
+		{"data:no-comma", "", true},
+		{"data:,a?b", "a?b", false},
+		{"data:,a?", "a?", false},
+		{"data:;base64,YT9i?", "", true},
+	}
+
+	client := NewHttpClient()
+	for _, tt := range tests {
+		data, err := client.Fetch(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (%q): want %t, got %v", tt.url, tt.err, err)
+		}
+		if string(data) != tt.data {
+			t.Errorf("bad data (%q): want %q, got %q", tt.url, tt.data, data)
+		}
+	}
+}
+
+func TestFetchFile(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "user-data")
+	if err := os.WriteFile(name, []byte("#cloud-config\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewHttpClient()
+	data, err := client.Fetch("file://" + name)
+	if err != nil || string(data) != "#cloud-config\n" {
+		t.Errorf("bad result: want (%q, nil), got (%q, %v)", "#cloud-config\n", data, err)
+	}
+
+	if _, err := client.Fetch("file://" + path.Join(dir, "missing")); err == nil {
+		t.Errorf("bad error: want ErrNotFound, got nil")
+	} else if _, ok := err.(ErrNotFound); !ok {
+		t.Errorf("bad error: want ErrNotFound, got %#v", err)
+	}
+
+	if _, err := client.Fetch("file://example.com" + name); err == nil {
+		t.Errorf("bad error: want ErrInvalid, got nil")
+	}
+}
+
+func TestFetchUnsupported(t *testing.T) {
+	client := NewHttpClient()
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{"", "URL is empty. Skipping."},
+		{"boo", "URL boo does not have a supported scheme. Skipping."},
+		{"ftp://boo", "URL ftp://boo does not have a supported scheme. Skipping."},
+	} {
+		if _, err := client.Fetch(tt.url); err == nil || err.Error() != tt.want {
+			t.Errorf("bad error (%q): want %q, got %v", tt.url, tt.want, err)
+		}
+	}
+}
+
+func TestRegisterFetcher(t *testing.T) {
+	defer delete(fetchers, "test")
+
+	if IsFetchable("test://foo") {
+		t.Fatalf("test scheme is unexpectedly fetchable")
+	}
+	RegisterFetcher("TEST", func(h *HttpClient, url *neturl.URL) ([]byte, error) {
+		return []byte(url.Host), nil
+	})
+	if !IsFetchable("test://foo") {
+		t.Fatalf("test scheme is not fetchable")
+	}
+
+	data, err := NewHttpClient().Fetch("test://foo")
+	if err != nil || string(data) != "foo" {
+		t.Errorf("bad result: want (%q, nil), got (%q, %v)", "foo", data, err)
+	}
+}
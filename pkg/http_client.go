@@ -15,12 +15,16 @@
 package pkg
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	neturl "net/url"
+	"os"
 	"strings"
 	"time"
 )
@@ -73,18 +77,166 @@ type Getter interface {
 	GetRetry(string) ([]byte, error)
 }
 
+// HttpClientOptions controls how an HttpClient reaches remote servers.
+type HttpClientOptions struct {
+	// Proxy is the URL of the proxy to use for all requests. When empty, the
+	// HTTPS_PROXY and HTTP_PROXY environment variables are used instead. Hosts
+	// matching NO_PROXY are always contacted directly.
+	Proxy string
+
+	// CABundle is the path to a PEM file with certificate authorities to
+	// trust in addition to the system roots.
+	CABundle string
+
+	// ClientCert and ClientKey are the paths to a PEM encoded certificate and
+	// key presented to servers requesting client authentication.
+	ClientCert string
+	ClientKey  string
+}
+
+// Transport builds an http.Transport honouring the options.
+func (o HttpClientOptions) Transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.Proxy != "" {
+		proxy, err := neturl.Parse(o.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", o.Proxy)
+		}
+		noProxy := os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+		transport.Proxy = func(req *http.Request) (*neturl.URL, error) {
+			if !useProxy(req.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	if o.CABundle == "" && o.ClientCert == "" && o.ClientKey == "" {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if o.CABundle != "" {
+		pem, err := ioutil.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %q", o.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.ClientCert != "" || o.ClientKey != "" {
+		if o.ClientCert == "" || o.ClientKey == "" {
+			return nil, errors.New("client certificate and key must be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// useProxy reports whether requests to host should go through a proxy given
+// the comma separated NO_PROXY list. Link-local addresses, where the metadata
+// services live, are never proxied.
+func useProxy(host, noProxy string) bool {
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+		return false
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return false
+		case ip != nil:
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return false
+			}
+			if ip.Equal(net.ParseIP(entry)) {
+				return false
+			}
+		default:
+			host = strings.ToLower(host)
+			entry = strings.TrimPrefix(entry, "*")
+			if host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var (
+	defaultOptions   HttpClientOptions
+	defaultTransport http.RoundTripper = http.DefaultTransport
+)
+
+// SetDefaultHttpClientOptions configures the options used by every
+// HttpClient created through NewHttpClient and NewHttpClientHeader.
+func SetDefaultHttpClientOptions(o HttpClientOptions) error {
+	transport, err := o.Transport()
+	if err != nil {
+		return err
+	}
+	defaultOptions = o
+	defaultTransport = transport
+	return nil
+}
+
+// DefaultHttpClientOptions returns the options set by
+// SetDefaultHttpClientOptions.
+func DefaultHttpClientOptions() HttpClientOptions {
+	return defaultOptions
+}
+
 func NewHttpClient() *HttpClient {
 	return NewHttpClientHeader(nil)
 }
 
 func NewHttpClientHeader(header http.Header) *HttpClient {
+	return newHttpClient(header, defaultTransport)
+}
+
+// NewHttpClientOptions creates an HttpClient using the given options instead
+// of the defaults.
+func NewHttpClientOptions(header http.Header, o HttpClientOptions) (*HttpClient, error) {
+	transport, err := o.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return newHttpClient(header, transport), nil
+}
+
+func newHttpClient(header http.Header, transport http.RoundTripper) *HttpClient {
 	hc := &HttpClient{
 		InitialBackoff: 50 * time.Millisecond,
 		MaxBackoff:     time.Second * 5,
 		MaxRetries:     15,
 		Header:         header,
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: transport,
 		},
 	}
 
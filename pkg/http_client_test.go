@@ -15,11 +15,13 @@
 package pkg
 
 import (
+	"encoding/pem"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 )
@@ -152,3 +154,100 @@ func TestGetMalformedURL(t *testing.T) {
 		}
 	}
 }
+
+func TestUseProxy(t *testing.T) {
+	tests := []struct {
+		host    string
+		noProxy string
+
+		want bool
+	}{
+		{"example.com", "", true},
+		{"localhost", "", false},
+		{"127.0.0.1", "", false},
+		{"169.254.169.254", "", false},
+		{"example.com", "*", false},
+		{"example.com", "example.com", false},
+		{"config.example.com", "example.com", false},
+		{"config.example.com", ".example.com", false},
+		{"notexample.com", "example.com", true},
+		{"10.1.2.3", "10.0.0.0/8", false},
+		{"192.168.1.1", "10.0.0.0/8, 192.168.1.1", false},
+		{"192.168.1.2", "10.0.0.0/8, 192.168.1.1", true},
+	}
+
+	for _, tt := range tests {
+		if got := useProxy(tt.host, tt.noProxy); got != tt.want {
+			t.Errorf("bad result (%q, %q): want %t, got %t", tt.host, tt.noProxy, tt.want, got)
+		}
+	}
+}
+
+// Test that an explicit proxy is used for requests
+func TestGetURLProxy(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		fmt.Fprint(w, "proxied")
+	}))
+	defer proxy.Close()
+
+	client, err := NewHttpClientOptions(nil, HttpClientOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+
+	data, err := client.Get("http://config.example.com/user-data")
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if !proxied || string(data) != "proxied" {
+		t.Errorf("request was not sent through the proxy")
+	}
+}
+
+// Test that servers signed by a CA from the bundle are trusted
+func TestGetURLCABundle(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	}))
+	defer ts.Close()
+
+	if _, err := NewHttpClient().Get(ts.URL); err == nil {
+		t.Fatalf("untrusted certificate was accepted")
+	}
+
+	bundle, err := os.CreateTemp("", "coreos-cloudinit-ca-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bundle.Name())
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	bundle.Close()
+
+	client, err := NewHttpClientOptions(nil, HttpClientOptions{CABundle: bundle.Name()})
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	data, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if string(data) != "secret" {
+		t.Errorf("bad data: want %q, got %q", "secret", data)
+	}
+}
+
+func TestHttpClientOptionsInvalid(t *testing.T) {
+	for _, o := range []HttpClientOptions{
+		{Proxy: "::"},
+		{CABundle: "/nonexistent/ca.pem"},
+		{ClientCert: "/nonexistent/cert.pem"},
+		{ClientKey: "/nonexistent/key.pem"},
+		{ClientCert: "/nonexistent/cert.pem", ClientKey: "/nonexistent/key.pem"},
+	} {
+		if _, err := o.Transport(); err == nil {
+			t.Errorf("bad error (%+v): want non-nil, got nil", o)
+		}
+	}
+}
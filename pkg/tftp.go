@@ -0,0 +1,127 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+const (
+	tftpOpRRQ   = 1
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+
+	tftpBlockSize = 512
+	tftpTimeout   = 5 * time.Second
+	tftpRetries   = 5
+)
+
+// fetchTFTP downloads a file using the read request of RFC 1350 in octet
+// mode.
+func fetchTFTP(_ *HttpClient, url *neturl.URL) ([]byte, error) {
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), "69")
+	}
+	server, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, ErrNetwork{err}
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, ErrNetwork{err}
+	}
+	defer conn.Close()
+
+	file := strings.TrimPrefix(url.Path, "/")
+	log.Printf("Fetching %s from TFTP server %s", file, server)
+
+	rrq := &bytes.Buffer{}
+	binary.Write(rrq, binary.BigEndian, uint16(tftpOpRRQ))
+	rrq.WriteString(file + "\x00octet\x00")
+
+	var (
+		data    bytes.Buffer
+		packet  = rrq.Bytes()
+		peer    = server
+		block   uint16
+		buf     = make([]byte, tftpBlockSize+4)
+		retries = 0
+	)
+	for {
+		if _, err := conn.WriteToUDP(packet, peer); err != nil {
+			return nil, ErrNetwork{err}
+		}
+
+		conn.SetReadDeadline(time.Now().Add(tftpTimeout))
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && retries < tftpRetries {
+				retries++
+				continue
+			}
+			return nil, ErrNetwork{fmt.Errorf("unable to fetch data: %w", err)}
+		}
+		if n < 4 {
+			continue
+		}
+
+		switch binary.BigEndian.Uint16(buf[:2]) {
+		case tftpOpData:
+			// The server answers from a new port which identifies the transfer.
+			if block == 0 {
+				peer = from
+			} else if !from.IP.Equal(peer.IP) || from.Port != peer.Port {
+				continue
+			}
+
+			number := binary.BigEndian.Uint16(buf[2:4])
+			if number == block+1 {
+				data.Write(buf[4:n])
+				block = number
+				retries = 0
+			}
+
+			ack := make([]byte, 4)
+			binary.BigEndian.PutUint16(ack[:2], tftpOpAck)
+			binary.BigEndian.PutUint16(ack[2:], number)
+			packet = ack
+
+			if number == block && n-4 < tftpBlockSize {
+				conn.WriteToUDP(packet, peer)
+				return data.Bytes(), nil
+			}
+		case tftpOpError:
+			code := binary.BigEndian.Uint16(buf[2:4])
+			msg := string(bytes.TrimRight(buf[4:n], "\x00"))
+			if code == 1 {
+				return nil, ErrNotFound{fmt.Errorf("TFTP file not found: %s", msg)}
+			}
+			return nil, ErrServer{fmt.Errorf("TFTP error %d: %s", code, msg)}
+		default:
+			return nil, ErrServer{errors.New("unexpected TFTP packet")}
+		}
+	}
+}
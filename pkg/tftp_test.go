@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveTFTP answers a single read request on conn with the given files,
+// sending the data from a separate transfer port like a real server.
+func serveTFTP(t *testing.T, conn *net.UDPConn, files map[string][]byte) {
+	buf := make([]byte, 1024)
+	n, client, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return
+	}
+	name := strings.SplitN(string(buf[2:n]), "\x00", 2)[0]
+
+	transfer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer transfer.Close()
+
+	data, ok := files[name]
+	if !ok {
+		packet := &bytes.Buffer{}
+		binary.Write(packet, binary.BigEndian, []uint16{tftpOpError, 1})
+		packet.WriteString("File not found\x00")
+		transfer.WriteToUDP(packet.Bytes(), client)
+		return
+	}
+
+	for block := 1; ; block++ {
+		end := block * tftpBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		packet := &bytes.Buffer{}
+		binary.Write(packet, binary.BigEndian, []uint16{tftpOpData, uint16(block)})
+		packet.Write(data[(block-1)*tftpBlockSize : end])
+		transfer.WriteToUDP(packet.Bytes(), client)
+
+		if _, _, err := transfer.ReadFromUDP(buf); err != nil {
+			t.Error(err)
+			return
+		}
+		if end == len(data) && packet.Len()-4 < tftpBlockSize {
+			return
+		}
+	}
+}
+
+func TestFetchTFTP(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 2*tftpBlockSize/16)
+	files := map[string][]byte{
+		"user-data":  []byte("#cloud-config\n"),
+		"large-data": large,
+	}
+
+	for _, tt := range []struct {
+		name string
+
+		data []byte
+		err  error
+	}{
+		{"user-data", files["user-data"], nil},
+		{"large-data", large, nil},
+		{"missing", nil, ErrNotFound{fmt.Errorf("TFTP file not found: File not found")}},
+	} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go serveTFTP(t, conn, files)
+
+		data, err := NewHttpClient().Fetch(fmt.Sprintf("tftp://%s/%s", conn.LocalAddr(), tt.name))
+		conn.Close()
+		if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+			t.Errorf("bad error (%q): want %v, got %v", tt.name, tt.err, err)
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("bad data (%q): want %d bytes, got %d bytes", tt.name, len(tt.data), len(data))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const (
+	certsDir          = "/etc/ssl/certs"
+	defaultCertsDir   = "/usr/share/ca-certificates"
+	updateCACertsUnit = "update-ca-certificates.service"
+)
+
+// CACerts is a top-level structure which embeds its underlying
+// configuration, config.CACerts, and provides the system-specific Files()
+// and Units().
+type CACerts struct {
+	config.CACerts
+}
+
+// Files generates a file in /etc/ssl/certs for each trusted certificate.
+func (cc CACerts) Files() []File {
+	var files []File
+	for i, cert := range cc.Trusted {
+		if !strings.HasSuffix(cert, "\n") {
+			cert += "\n"
+		}
+		files = append(files, File{config.File{
+			Path:               path.Join(certsDir, fmt.Sprintf("cloudinit-%d.pem", i)),
+			RawFilePermissions: "0644",
+			Content:            cert,
+		}})
+	}
+	return files
+}
+
+// Units generates a unit running update-ca-certificates, which rehashes the
+// certificates and rebuilds the bundle, if the certificates changed.
+func (cc CACerts) Units() []Unit {
+	if len(cc.Trusted) == 0 && !cc.RemoveDefaults {
+		return nil
+	}
+	return []Unit{{config.Unit{
+		Name:    updateCACertsUnit,
+		Command: "restart",
+	}}}
+}
+
+// Prune removes the certificates of /etc/ssl/certs under root which were
+// written for an earlier cloud-config and are no longer trusted. If any was
+// removed, it returns the units rebuilding the bundle without them which
+// Units() doesn't.
+func (cc CACerts) Prune(root string) ([]Unit, error) {
+	trusted := map[string]bool{}
+	for _, f := range cc.Files() {
+		trusted[path.Base(f.Path)] = true
+	}
+	certs, err := filepath.Glob(path.Join(root, certsDir, "cloudinit-*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := false
+	for _, cert := range certs {
+		if trusted[path.Base(cert)] {
+			continue
+		}
+		if err := os.Remove(cert); err != nil {
+			return nil, err
+		}
+		log.Printf("Removed the CA certificate %q which is no longer trusted", cert)
+		pruned = true
+	}
+	if !pruned || len(cc.Units()) > 0 {
+		return nil, nil
+	}
+	return []Unit{{config.Unit{
+		Name:    updateCACertsUnit,
+		Command: "restart",
+	}}}, nil
+}
+
+// RemoveDefaultCACerts removes the links of /etc/ssl/certs under root to the
+// default certificates, which are linked again on every boot.
+func RemoveDefaultCACerts(root string) error {
+	dir := path.Join(root, certsDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(certsDir, target)
+		}
+		if target != defaultCertsDir && !strings.HasPrefix(target, defaultCertsDir+"/") {
+			continue
+		}
+		if err := os.Remove(path.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	log.Printf("Removed the default CA certificates from %q", dir)
+	return nil
+}
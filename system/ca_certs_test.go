@@ -0,0 +1,159 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func TestCACerts(t *testing.T) {
+	for _, tt := range []struct {
+		config config.CACerts
+		files  []File
+		units  []Unit
+	}{
+		{
+			config: config.CACerts{},
+		},
+		{
+			config: config.CACerts{RemoveDefaults: true},
+			units:  []Unit{{config.Unit{Name: "update-ca-certificates.service", Command: "restart"}}},
+		},
+		{
+			config: config.CACerts{Trusted: []string{"-----BEGIN CERTIFICATE-----\nA\n-----END CERTIFICATE-----", "-----BEGIN CERTIFICATE-----\nB\n-----END CERTIFICATE-----\n"}},
+			files: []File{{config.File{
+				Path:               "/etc/ssl/certs/cloudinit-0.pem",
+				RawFilePermissions: "0644",
+				Content:            "-----BEGIN CERTIFICATE-----\nA\n-----END CERTIFICATE-----\n",
+			}}, {config.File{
+				Path:               "/etc/ssl/certs/cloudinit-1.pem",
+				RawFilePermissions: "0644",
+				Content:            "-----BEGIN CERTIFICATE-----\nB\n-----END CERTIFICATE-----\n",
+			}}},
+			units: []Unit{{config.Unit{Name: "update-ca-certificates.service", Command: "restart"}}},
+		},
+	} {
+		cc := CACerts{tt.config}
+		if files := cc.Files(); !reflect.DeepEqual(tt.files, files) {
+			t.Errorf("bad files (%+v): want %#v, got %#v", tt.config, tt.files, files)
+		}
+		if units := cc.Units(); !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (%+v): want %#v, got %#v", tt.config, tt.units, units)
+		}
+	}
+}
+
+func TestRemoveDefaultCACerts(t *testing.T) {
+	root := t.TempDir()
+	dir := path.Join(root, certsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Unable to create certs: %v", err)
+	}
+	for name, target := range map[string]string{
+		"default.pem":  "/usr/share/ca-certificates/mozilla/default.crt",
+		"relative.pem": "../../../usr/share/ca-certificates/mozilla/relative.crt",
+		"other.pem":    "/etc/pki/other.pem",
+	} {
+		if err := os.Symlink(target, path.Join(dir, name)); err != nil {
+			t.Fatalf("Unable to create link: %v", err)
+		}
+	}
+	if err := os.WriteFile(path.Join(dir, "cloudinit-0.pem"), nil, 0644); err != nil {
+		t.Fatalf("Unable to write certificate: %v", err)
+	}
+
+	if err := RemoveDefaultCACerts(root); err != nil {
+		t.Fatalf("RemoveDefaultCACerts failed: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unable to read certs: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if want := []string{"cloudinit-0.pem", "other.pem"}; !reflect.DeepEqual(want, names) {
+		t.Errorf("bad certificates: want %q, got %q", want, names)
+	}
+
+	if err := RemoveDefaultCACerts(path.Join(root, "nonexistent")); err != nil {
+		t.Errorf("RemoveDefaultCACerts failed without certs: %v", err)
+	}
+}
+
+func TestPruneCACerts(t *testing.T) {
+	root := t.TempDir()
+	dir := path.Join(root, certsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Unable to create certs: %v", err)
+	}
+	for _, name := range []string{"cloudinit-0.pem", "cloudinit-1.pem", "other.pem"} {
+		if err := os.WriteFile(path.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Unable to write certificate: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		config config.CACerts
+		names  []string
+		units  []Unit
+	}{
+		{
+			config: config.CACerts{Trusted: []string{"A", "B"}},
+			names:  []string{"cloudinit-0.pem", "cloudinit-1.pem", "other.pem"},
+		},
+		{
+			// The unit is already generated by Units().
+			config: config.CACerts{Trusted: []string{"A"}},
+			names:  []string{"cloudinit-0.pem", "other.pem"},
+		},
+		{
+			config: config.CACerts{},
+			names:  []string{"other.pem"},
+			units:  []Unit{{config.Unit{Name: "update-ca-certificates.service", Command: "restart"}}},
+		},
+		{
+			config: config.CACerts{},
+			names:  []string{"other.pem"},
+		},
+	} {
+		units, err := CACerts{tt.config}.Prune(root)
+		if err != nil {
+			t.Fatalf("Prune failed (%+v): %v", tt.config, err)
+		}
+		if !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (%+v): want %#v, got %#v", tt.config, tt.units, units)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Unable to read certs: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if !reflect.DeepEqual(tt.names, names) {
+			t.Errorf("bad certificates (%+v): want %q, got %q", tt.config, tt.names, names)
+		}
+	}
+}
@@ -19,33 +19,85 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 )
 
-const DefaultIpv4Address = "127.0.0.1"
+const (
+	DefaultIpv4Address = "127.0.0.1"
+	DefaultIpv6Address = "::1"
+)
 
+// EtcHosts is a top-level structure which embeds its underlying
+// configuration, config.EtcHosts, along with the hostname and FQDN
+// determined by the run, the private IPv4 address of the metadata, the
+// user-supplied template and the function substituting its variables. It
+// provides the system-specific File().
 type EtcHosts struct {
 	config.EtcHosts
+	Hostname    string
+	FQDN        string
+	PrivateIPv4 string
+	Template    string
+	Substitute  func(string) string
 }
 
-func (eh EtcHosts) generateEtcHosts() (out string, err error) {
-	if eh.EtcHosts != "localhost" {
-		return "", errors.New("Invalid option to manage_etc_hosts")
+// names returns the short hostname, falling back to the hostname of the
+// operating system, and the FQDN, falling back to the hostname. The hostname
+// may be the FQDN itself.
+func (eh EtcHosts) names() (hostname, fqdn string, err error) {
+	hostname = eh.Hostname
+	if hostname == "" {
+		if hostname, err = os.Hostname(); err != nil {
+			return "", "", err
+		}
 	}
+	fqdn = eh.FQDN
+	if fqdn == "" {
+		fqdn = hostname
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return hostname, fqdn, nil
+}
 
-	// use the operating system hostname
-	hostname, err := os.Hostname()
+func (eh EtcHosts) generateEtcHosts() (out string, err error) {
+	hostname, fqdn, err := eh.names()
 	if err != nil {
 		return "", err
 	}
+	names := hostname
+	if fqdn != hostname {
+		names = fqdn + " " + hostname
+	}
+	localhost := fmt.Sprintf("%s localhost\n%s localhost\n", DefaultIpv4Address, DefaultIpv6Address)
+	loopback := fmt.Sprintf("%s %s\n%s %s\n", DefaultIpv4Address, names, DefaultIpv6Address, names)
 
-	return fmt.Sprintf("%s %s\n", DefaultIpv4Address, hostname), nil
-
+	switch eh.EtcHosts {
+	case "localhost":
+		return localhost + loopback, nil
+	case "true", "template":
+		if eh.Template != "" {
+			out = strings.NewReplacer("$fqdn", fqdn, "$hostname", hostname).Replace(eh.Template)
+			if eh.Substitute != nil {
+				out = eh.Substitute(out)
+			}
+			return out, nil
+		}
+		if eh.EtcHosts == "template" {
+			return "", errors.New("manage_etc_hosts is \"template\" but no etc_hosts_template is given")
+		}
+		if eh.PrivateIPv4 != "" {
+			return localhost + fmt.Sprintf("%s %s\n", eh.PrivateIPv4, names), nil
+		}
+		return localhost + loopback, nil
+	default:
+		return "", errors.New("Invalid option to manage_etc_hosts")
+	}
 }
 
 func (eh EtcHosts) File() (*File, error) {
-	if eh.EtcHosts == "" {
+	if eh.EtcHosts == "" || eh.EtcHosts == "false" {
 		return nil, nil
 	}
 
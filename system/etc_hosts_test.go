@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/flatcar/coreos-cloudinit/config"
@@ -30,31 +31,91 @@ func TestEtcdHostsFile(t *testing.T) {
 	}
 
 	for _, tt := range []struct {
-		config config.EtcHosts
+		config EtcHosts
 		file   *File
 		err    error
 	}{
 		{
-			"invalid",
+			EtcHosts{EtcHosts: "invalid"},
 			nil,
 			fmt.Errorf("Invalid option to manage_etc_hosts"),
 		},
 		{
-			"localhost",
+			EtcHosts{EtcHosts: "false"},
+			nil,
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "localhost"},
+			&File{config.File{
+				Content:            fmt.Sprintf("127.0.0.1 localhost\n::1 localhost\n127.0.0.1 %s\n::1 %s\n", hostname, hostname),
+				Path:               "etc/hosts",
+				RawFilePermissions: "0644",
+			}},
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "localhost", Hostname: "host", FQDN: "host.example.com", PrivateIPv4: "10.0.0.5"},
+			&File{config.File{
+				Content:            "127.0.0.1 localhost\n::1 localhost\n127.0.0.1 host.example.com host\n::1 host.example.com host\n",
+				Path:               "etc/hosts",
+				RawFilePermissions: "0644",
+			}},
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "localhost", Hostname: "host.example.com", FQDN: "host.example.com"},
+			&File{config.File{
+				Content:            "127.0.0.1 localhost\n::1 localhost\n127.0.0.1 host.example.com host\n::1 host.example.com host\n",
+				Path:               "etc/hosts",
+				RawFilePermissions: "0644",
+			}},
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "true", Hostname: "host", FQDN: "host.example.com", PrivateIPv4: "10.0.0.5"},
+			&File{config.File{
+				Content:            "127.0.0.1 localhost\n::1 localhost\n10.0.0.5 host.example.com host\n",
+				Path:               "etc/hosts",
+				RawFilePermissions: "0644",
+			}},
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "true", Hostname: "host"},
+			&File{config.File{
+				Content:            "127.0.0.1 localhost\n::1 localhost\n127.0.0.1 host\n::1 host\n",
+				Path:               "etc/hosts",
+				RawFilePermissions: "0644",
+			}},
+			nil,
+		},
+		{
+			EtcHosts{EtcHosts: "template", Hostname: "host"},
+			nil,
+			fmt.Errorf("manage_etc_hosts is \"template\" but no etc_hosts_template is given"),
+		},
+		{
+			EtcHosts{
+				EtcHosts:   "template",
+				Hostname:   "host",
+				Template:   "127.0.0.1 localhost\n$private_ipv4 $fqdn $hostname\n",
+				Substitute: strings.NewReplacer("$private_ipv4", "10.0.0.5").Replace,
+			},
 			&File{config.File{
-				Content:            fmt.Sprintf("127.0.0.1 %s\n", hostname),
+				Content:            "127.0.0.1 localhost\n10.0.0.5 host host\n",
 				Path:               "etc/hosts",
 				RawFilePermissions: "0644",
 			}},
 			nil,
 		},
 	} {
-		file, err := EtcHosts{tt.config}.File()
+		file, err := tt.config.File()
 		if !reflect.DeepEqual(tt.err, err) {
-			t.Errorf("bad error (%q): want %q, got %q", tt.config, tt.err, err)
+			t.Errorf("bad error (%q): want %q, got %q", tt.config.EtcHosts, tt.err, err)
 		}
 		if !reflect.DeepEqual(tt.file, file) {
-			t.Errorf("bad units (%q): want %#v, got %#v", tt.config, tt.file, file)
+			t.Errorf("bad units (%q): want %#v, got %#v", tt.config.EtcHosts, tt.file, file)
 		}
 	}
 }
@@ -19,7 +19,6 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
 
@@ -33,14 +32,35 @@ type File struct {
 }
 
 func (f *File) Permissions() (os.FileMode, error) {
-	if f.RawFilePermissions == "" {
-		return os.FileMode(0644), nil
+	return parsePermissions(f.RawFilePermissions, 0644)
+}
+
+// Directory is a top-level structure which embeds its underlying
+// configuration, config.Directory, and provides the system-specific
+// Permissions().
+type Directory struct {
+	config.Directory
+}
+
+func (d *Directory) Permissions() (os.FileMode, error) {
+	return parsePermissions(d.RawFilePermissions, 0755)
+}
+
+// Link is a top-level structure which embeds its underlying configuration,
+// config.Link.
+type Link struct {
+	config.Link
+}
+
+func parsePermissions(raw string, def os.FileMode) (os.FileMode, error) {
+	if raw == "" {
+		return def, nil
 	}
 
 	// Parse string representation of file mode as integer
-	perm, err := strconv.ParseInt(f.RawFilePermissions, 8, 32)
+	perm, err := strconv.ParseInt(raw, 8, 32)
 	if err != nil {
-		return 0, fmt.Errorf("Unable to parse file permissions %q as integer", f.RawFilePermissions)
+		return 0, fmt.Errorf("Unable to parse file permissions %q as integer", raw)
 	}
 	return os.FileMode(perm), nil
 }
@@ -64,6 +84,15 @@ func WriteFile(f *File, root string) (string, error) {
 		return "", err
 	}
 
+	uid, gid, err := LookupOwner(f.Owner, root)
+	if err != nil {
+		return "", err
+	}
+
+	if f.Append {
+		return appendFile(f, fullpath, perm, uid, gid)
+	}
+
 	var tmp *os.File
 	// Create a temporary file in the same directory to ensure it's on the same filesystem
 	if tmp, err = ioutil.TempFile(dir, "cloudinit-temp"); err != nil {
@@ -83,12 +112,8 @@ func WriteFile(f *File, root string) (string, error) {
 		return "", err
 	}
 
-	if f.Owner != "" {
-		// We shell out since we don't have a way to look up unix groups natively
-		cmd := exec.Command("chown", f.Owner, tmp.Name())
-		if err := cmd.Run(); err != nil {
-			return "", err
-		}
+	if err := os.Lchown(tmp.Name(), uid, gid); err != nil {
+		return "", err
 	}
 
 	if err := os.Rename(tmp.Name(), fullpath); err != nil {
@@ -99,6 +124,37 @@ func WriteFile(f *File, root string) (string, error) {
 	return fullpath, nil
 }
 
+// appendFile appends the content of the file to the existing one, keeping
+// its permissions and owner unless they are set, or creates it.
+func appendFile(f *File, fullpath string, perm os.FileMode, uid, gid int) (string, error) {
+	_, err := os.Stat(fullpath)
+	created := os.IsNotExist(err)
+
+	out, err := os.OpenFile(fullpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, perm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := out.WriteString(f.Content); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	if created || f.RawFilePermissions != "" {
+		if err := os.Chmod(fullpath, perm); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Lchown(fullpath, uid, gid); err != nil {
+		return "", err
+	}
+
+	log.Printf("Appended to file %q", fullpath)
+	return fullpath, nil
+}
+
 func EnsureDirectoryExists(dir string) error {
 	info, err := os.Stat(dir)
 	if err == nil {
@@ -113,3 +169,108 @@ func EnsureDirectoryExists(dir string) error {
 	}
 	return nil
 }
+
+// WriteDirectory creates the directory of the cloud-config under root. An
+// existing file which isn't a directory is only replaced if the directory
+// has overwrite set.
+func WriteDirectory(d *Directory, root string) (string, error) {
+	perm, err := d.Permissions()
+	if err != nil {
+		return "", err
+	}
+
+	fullpath := path.Join(root, d.Path)
+	if info, err := os.Lstat(fullpath); err == nil && !info.IsDir() {
+		if !d.Overwrite {
+			return "", fmt.Errorf("%s already exists and is not a directory", fullpath)
+		}
+		if _, _, err := LookupOwner(d.Owner, root); err != nil {
+			return "", err
+		}
+		if err := os.Remove(fullpath); err != nil {
+			return "", err
+		}
+	}
+	return MakeDirectory(d.Path, perm, d.Owner, root)
+}
+
+// WriteLink creates the link of the cloud-config under root.
+func WriteLink(l *Link, root string) (string, error) {
+	return MakeLink(l.Path, l.Target, l.Hard, l.Overwrite, l.Owner, root)
+}
+
+// MakeDirectory creates the directory under root, and its missing parents,
+// and sets its permissions and, if not empty, its owner.
+func MakeDirectory(dir string, perm os.FileMode, owner string, root string) (string, error) {
+	uid, gid, err := LookupOwner(owner, root)
+	if err != nil {
+		return "", err
+	}
+	fullpath := path.Join(root, dir)
+	if err := EnsureDirectoryExists(fullpath); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(fullpath, perm); err != nil {
+		return "", err
+	}
+	if err := os.Lchown(fullpath, uid, gid); err != nil {
+		return "", err
+	}
+	log.Printf("Created directory %q", fullpath)
+	return fullpath, nil
+}
+
+// MakeLink creates a link under root to target: a symbolic link, whose
+// target is kept as is, or a hard link, whose target is also under root.
+// An existing link to the same target is kept; other existing files are
+// only replaced if overwrite is set. If owner is not empty, it is set on the
+// link itself.
+func MakeLink(link, target string, hard, overwrite bool, owner string, root string) (string, error) {
+	uid, gid, err := LookupOwner(owner, root)
+	if err != nil {
+		return "", err
+	}
+	fullpath := path.Join(root, link)
+	if err := EnsureDirectoryExists(path.Dir(fullpath)); err != nil {
+		return "", err
+	}
+
+	if info, err := os.Lstat(fullpath); err == nil {
+		switch {
+		case !hard && info.Mode()&os.ModeSymlink != 0 && readLink(fullpath) == target:
+		case hard && sameFile(info, path.Join(root, target)):
+		case !overwrite:
+			return "", fmt.Errorf("%s already exists", fullpath)
+		default:
+			if err := os.Remove(fullpath); err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, err := os.Lstat(fullpath); os.IsNotExist(err) {
+		if hard {
+			err = os.Link(path.Join(root, target), fullpath)
+		} else {
+			err = os.Symlink(target, fullpath)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Lchown(fullpath, uid, gid); err != nil {
+		return "", err
+	}
+	log.Printf("Linked %q to %q", fullpath, target)
+	return fullpath, nil
+}
+
+func readLink(name string) string {
+	target, _ := os.Readlink(name)
+	return target
+}
+
+func sameFile(info os.FileInfo, name string) bool {
+	other, err := os.Stat(name)
+	return err == nil && os.SameFile(info, other)
+}
@@ -147,6 +147,59 @@ func TestWriteFilePermissions(t *testing.T) {
 	}
 }
 
+func TestWriteFileAppend(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fullPath := path.Join(dir, "foo")
+	if err := ioutil.WriteFile(fullPath, []byte("foo\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	wf := File{config.File{
+		Path:    "foo",
+		Content: "bar\n",
+		Append:  true,
+	}}
+	if _, err := WriteFile(&wf, dir); err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to stat file: %v", err)
+	}
+	if fi.Mode() != os.FileMode(0600) {
+		t.Errorf("File has incorrect mode: %v", fi.Mode())
+	}
+	contents, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to read expected file: %v", err)
+	}
+	if string(contents) != "foo\nbar\n" {
+		t.Fatalf("File has incorrect contents: %q", contents)
+	}
+
+	wf = File{config.File{
+		Path:               "new",
+		Content:            "bar",
+		RawFilePermissions: "0640",
+		Append:             true,
+	}}
+	if _, err := WriteFile(&wf, dir); err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+	if fi, err = os.Stat(path.Join(dir, "new")); err != nil {
+		t.Fatalf("Unable to stat file: %v", err)
+	}
+	if fi.Mode() != os.FileMode(0640) {
+		t.Errorf("File has incorrect mode: %v", fi.Mode())
+	}
+}
+
 func TestWriteFileInvalidEncodedContent(t *testing.T) {
 	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
 	if err != nil {
@@ -195,3 +248,103 @@ func TestWriteFileUnknownEncodedContent(t *testing.T) {
 		t.Fatalf("Expected error to be raised when writing file with encoding")
 	}
 }
+
+func TestMakeDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	fullPath, err := MakeDirectory("/a/b", 0700, "", dir)
+	if err != nil {
+		t.Fatalf("MakeDirectory failed: %v", err)
+	} else if fullPath != path.Join(dir, "a/b") {
+		t.Fatalf("MakeDirectory returned bad path: want %s, got %s", path.Join(dir, "a/b"), fullPath)
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to stat directory: %v", err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Errorf("Directory has incorrect mode: %v", fi.Mode())
+	}
+}
+
+func TestWriteDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(dir, "file"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	wd := Directory{config.Directory{Path: "/var/lib/app"}}
+	fullPath, err := WriteDirectory(&wd, dir)
+	if err != nil {
+		t.Fatalf("WriteDirectory failed: %v", err)
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to stat directory: %v", err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0755 {
+		t.Errorf("Directory has incorrect mode: %v", fi.Mode())
+	}
+
+	wd = Directory{config.Directory{Path: "/file", RawFilePermissions: "0700"}}
+	if _, err := WriteDirectory(&wd, dir); err == nil {
+		t.Errorf("WriteDirectory replaced a file without overwrite")
+	}
+
+	wd.Overwrite = true
+	if fullPath, err = WriteDirectory(&wd, dir); err != nil {
+		t.Fatalf("WriteDirectory failed: %v", err)
+	}
+	if fi, err = os.Stat(fullPath); err != nil {
+		t.Fatalf("Unable to stat directory: %v", err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Errorf("Directory has incorrect mode: %v", fi.Mode())
+	}
+}
+
+func TestMakeLink(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(dir, "target"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		link      string
+		target    string
+		hard      bool
+		overwrite bool
+		err       bool
+	}{
+		{"/etc/link", "/target", false, false, false},
+		// Links to the same target are kept.
+		{"/etc/link", "/target", false, false, false},
+		{"/etc/link", "/other", false, false, true},
+		{"/etc/link", "/other", false, true, false},
+		{"/etc/hard", "/target", true, false, false},
+		{"/etc/hard", "/target", true, false, false},
+		{"/target", "/etc/hard", true, false, false},
+	}
+	for _, tt := range tests {
+		_, err := MakeLink(tt.link, tt.target, tt.hard, tt.overwrite, "", dir)
+		if tt.err {
+			if err == nil {
+				t.Errorf("MakeLink(%s, %s) succeeded, want an error", tt.link, tt.target)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("MakeLink(%s, %s) failed: %v", tt.link, tt.target, err)
+			continue
+		}
+
+		fullPath := path.Join(dir, tt.link)
+		if tt.hard {
+			contents, err := ioutil.ReadFile(fullPath)
+			if err != nil || string(contents) != "hi" {
+				t.Errorf("Hard link %s has incorrect contents: %q (%v)", tt.link, contents, err)
+			}
+		} else if target, err := os.Readlink(fullPath); err != nil || target != tt.target {
+			t.Errorf("Link %s has incorrect target: want %s, got %s (%v)", tt.link, tt.target, target, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const modulesLoadUnit = "systemd-modules-load.service"
+
+// KernelModules is a top-level structure which embeds its underlying
+// configuration, config.KernelModules, and provides the system-specific
+// Files() and Units().
+type KernelModules struct {
+	config.KernelModules
+}
+
+// Files generates an `/etc/modules-load.d/90-cloudinit.conf` file listing
+// the modules and, if any module has options, an
+// `/etc/modprobe.d/90-cloudinit.conf` file with their options.
+func (km KernelModules) Files() ([]File, error) {
+	if len(km.KernelModules) == 0 {
+		return nil, nil
+	}
+
+	var modules, options string
+	for _, m := range km.KernelModules {
+		if err := config.AssertStructValid(m); err != nil {
+			return nil, err
+		}
+		if m.Name == "" {
+			return nil, fmt.Errorf("kernel module without a name")
+		}
+		modules += m.Name + "\n"
+		if m.Options != "" {
+			options += fmt.Sprintf("options %s %s\n", m.Name, m.Options)
+		}
+	}
+
+	files := []File{{config.File{
+		Path:               path.Join("etc", "modules-load.d", "90-cloudinit.conf"),
+		RawFilePermissions: "0644",
+		Content:            modules,
+	}}}
+	if options != "" {
+		files = append(files, File{config.File{
+			Path:               path.Join("etc", "modprobe.d", "90-cloudinit.conf"),
+			RawFilePermissions: "0644",
+			Content:            options,
+		}})
+	}
+	return files, nil
+}
+
+// Units generates a unit restarting systemd-modules-load, for the modules to
+// be loaded immediately. The options only apply to modules which aren't
+// loaded yet.
+func (km KernelModules) Units() []Unit {
+	if len(km.KernelModules) == 0 {
+		return nil
+	}
+	return []Unit{{config.Unit{
+		Name:    modulesLoadUnit,
+		Command: "restart",
+	}}}
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func TestKernelModules(t *testing.T) {
+	for _, tt := range []struct {
+		config config.KernelModules
+		files  []File
+		units  []Unit
+		err    bool
+	}{
+		{
+			config: config.KernelModules{},
+		},
+		{
+			config: config.KernelModules{{Name: "br_netfilter"}},
+			files: []File{{config.File{
+				Path:               "etc/modules-load.d/90-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "br_netfilter\n",
+			}}},
+			units: []Unit{{config.Unit{Name: "systemd-modules-load.service", Command: "restart"}}},
+		},
+		{
+			config: config.KernelModules{{Name: "br_netfilter"}, {Name: "loop", Options: "max_loop=64"}},
+			files: []File{{config.File{
+				Path:               "etc/modules-load.d/90-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "br_netfilter\nloop\n",
+			}}, {config.File{
+				Path:               "etc/modprobe.d/90-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "options loop max_loop=64\n",
+			}}},
+			units: []Unit{{config.Unit{Name: "systemd-modules-load.service", Command: "restart"}}},
+		},
+		{
+			config: config.KernelModules{{Options: "max_loop=64"}},
+			units:  []Unit{{config.Unit{Name: "systemd-modules-load.service", Command: "restart"}}},
+			err:    true,
+		},
+		{
+			config: config.KernelModules{{Name: "loop\nmalicious"}},
+			units:  []Unit{{config.Unit{Name: "systemd-modules-load.service", Command: "restart"}}},
+			err:    true,
+		},
+	} {
+		km := KernelModules{tt.config}
+		files, err := km.Files()
+		if tt.err != (err != nil) {
+			t.Errorf("bad error (%+v): want error %t, got %v", tt.config, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.files, files) {
+			t.Errorf("bad files (%+v): want %#v, got %#v", tt.config, tt.files, files)
+		}
+		if units := km.Units(); !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (%+v): want %#v, got %#v", tt.config, tt.units, units)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const (
+	zoneinfoDir = "/usr/share/zoneinfo"
+	localtime   = "/etc/localtime"
+	localeConf  = "/etc/locale.conf"
+	vconsole    = "/etc/vconsole.conf"
+)
+
+// CheckTimezone returns an error if the timezone, e.g. "Europe/Berlin", is
+// not in the zoneinfo database of the system under root.
+func CheckTimezone(name, root string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "..") {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	info, err := os.Stat(path.Join(root, zoneinfoDir, name))
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("unknown timezone %q", name)
+	}
+	return nil
+}
+
+// SetTimezone points /etc/localtime under root at the zoneinfo of the
+// timezone, as timedatectl does.
+func SetTimezone(name, root string) error {
+	if name == "" {
+		return nil
+	}
+	if err := CheckTimezone(name, root); err != nil {
+		return err
+	}
+	_, err := MakeLink(localtime, path.Join("..", zoneinfoDir, name), false, true, "", root)
+	return err
+}
+
+// SetLocale sets LANG in /etc/locale.conf under root, keeping the other
+// variables, as localectl does.
+func SetLocale(locale, root string) error {
+	return setConfVar(localeConf, "LANG", locale, root)
+}
+
+// SetKeymap sets KEYMAP in /etc/vconsole.conf under root, keeping the other
+// variables, as localectl does.
+func SetKeymap(keymap, root string) error {
+	return setConfVar(vconsole, "KEYMAP", keymap, root)
+}
+
+func setConfVar(file, key, value, root string) error {
+	if value == "" {
+		return nil
+	}
+	return WriteEnvFile(&EnvFile{
+		Vars: map[string]string{key: value},
+		File: &File{config.File{
+			Path:               file,
+			RawFilePermissions: "0644",
+		}},
+	}, root)
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSetTimezone(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(path.Join(root, zoneinfoDir, "Europe"), 0755); err != nil {
+		t.Fatalf("Unable to create zoneinfo: %v", err)
+	}
+	for _, zone := range []string{"UTC", "Europe/Berlin"} {
+		if err := os.WriteFile(path.Join(root, zoneinfoDir, zone), []byte("TZif"), 0644); err != nil {
+			t.Fatalf("Unable to write zoneinfo: %v", err)
+		}
+	}
+	if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+		t.Fatalf("Unable to create etc: %v", err)
+	}
+	if err := os.Symlink("../usr/share/zoneinfo/UTC", path.Join(root, localtime)); err != nil {
+		t.Fatalf("Unable to create localtime: %v", err)
+	}
+
+	tests := []struct {
+		name string
+
+		err bool
+	}{
+		{name: "Europe/Berlin"},
+		{name: "UTC"},
+		{name: "Europe", err: true},
+		{name: "Mars/Olympus_Mons", err: true},
+		{name: "../../../etc/passwd", err: true},
+		{name: "/usr/share/zoneinfo/UTC", err: true},
+	}
+
+	for _, tt := range tests {
+		err := SetTimezone(tt.name, root)
+		if tt.err != (err != nil) {
+			t.Errorf("bad error (%q): want error %t, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		target, err := os.Readlink(path.Join(root, localtime))
+		if err != nil {
+			t.Errorf("bad link (%q): %v", tt.name, err)
+		} else if want := "../usr/share/zoneinfo/" + tt.name; target != want {
+			t.Errorf("bad link (%q): want %q, got %q", tt.name, want, target)
+		}
+	}
+}
+
+func TestSetLocaleAndKeymap(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+		t.Fatalf("Unable to create etc: %v", err)
+	}
+	if err := os.WriteFile(path.Join(root, localeConf), []byte("LANG=C\nLC_TIME=en_GB.UTF-8\n"), 0644); err != nil {
+		t.Fatalf("Unable to write locale.conf: %v", err)
+	}
+
+	if err := SetLocale("de_DE.UTF-8", root); err != nil {
+		t.Fatalf("SetLocale failed: %v", err)
+	}
+	if err := SetKeymap("de-latin1", root); err != nil {
+		t.Fatalf("SetKeymap failed: %v", err)
+	}
+
+	for file, want := range map[string]string{
+		localeConf: "LANG=de_DE.UTF-8\nLC_TIME=en_GB.UTF-8\n",
+		vconsole:   "KEYMAP=de-latin1\n",
+	} {
+		contents, err := os.ReadFile(path.Join(root, file))
+		if err != nil {
+			t.Errorf("Unable to read %s: %v", file, err)
+		} else if string(contents) != want {
+			t.Errorf("bad contents of %s: want %q, got %q", file, want, contents)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const (
+	timesyncdUnit = "systemd-timesyncd.service"
+	chronydUnit   = "chronyd.service"
+	chronyClient  = "chrony"
+)
+
+// NTP is a top-level structure which embeds its underlying configuration,
+// config.NTP, and provides the system-specific File() and Units().
+type NTP struct {
+	config.NTP
+}
+
+func (nc NTP) chrony() bool {
+	return nc.Client == chronyClient
+}
+
+// File generates a timesyncd drop-in or, if chrony is the client, the
+// chrony configuration, with the configured servers and pools.
+func (nc NTP) File() (*File, error) {
+	if config.IsZero(nc.NTP) || nc.Enabled == "false" {
+		return nil, nil
+	}
+	if err := config.AssertStructValid(nc.NTP); err != nil {
+		return nil, err
+	}
+	if len(nc.Servers) == 0 && len(nc.Pools) == 0 && len(nc.FallbackServers) == 0 {
+		return nil, nil
+	}
+
+	if nc.chrony() {
+		// Without servers, the stock configuration is kept rather than
+		// leaving chrony nothing to synchronize with.
+		if len(nc.Servers) == 0 && len(nc.Pools) == 0 {
+			return nil, nil
+		}
+		var out string
+		for _, s := range nc.Servers {
+			out += fmt.Sprintf("server %s iburst\n", s)
+		}
+		for _, p := range nc.Pools {
+			out += fmt.Sprintf("pool %s iburst\n", p)
+		}
+		out += "driftfile /var/lib/chrony/drift\nmakestep 1.0 3\nrtcsync\n"
+		return &File{config.File{
+			Path:               path.Join("etc", "chrony", "chrony.conf"),
+			RawFilePermissions: "0644",
+			Content:            out,
+		}}, nil
+	}
+
+	// timesyncd doesn't tell pools apart from servers.
+	out := "[Time]\n"
+	if servers := append(append([]string{}, nc.Servers...), nc.Pools...); len(servers) > 0 {
+		out += fmt.Sprintf("NTP=%s\n", strings.Join(servers, " "))
+	}
+	if len(nc.FallbackServers) > 0 {
+		out += fmt.Sprintf("FallbackNTP=%s\n", strings.Join(nc.FallbackServers, " "))
+	}
+	return &File{config.File{
+		Path:               path.Join("etc", "systemd", "timesyncd.conf.d", "50-cloudinit.conf"),
+		RawFilePermissions: "0644",
+		Content:            out,
+	}}, nil
+}
+
+// Units generates units for the cloud-init initializer to act on:
+// - the client restarted, to pick up its configuration, or stopped and
+// masked if "enabled" is false
+// - timesyncd stopped and masked, if chrony is the client
+func (nc NTP) Units() []Unit {
+	if config.IsZero(nc.NTP) {
+		return nil
+	}
+
+	client := Unit{config.Unit{
+		Name:    timesyncdUnit,
+		Command: "restart",
+		Runtime: true,
+	}}
+	if nc.chrony() {
+		client.Name = chronydUnit
+	}
+	if nc.Enabled == "false" {
+		client.Command = "stop"
+		client.Mask = true
+	}
+
+	if !nc.chrony() {
+		return []Unit{client}
+	}
+	return []Unit{{config.Unit{
+		Name:    timesyncdUnit,
+		Command: "stop",
+		Mask:    true,
+		Runtime: true,
+	}}, client}
+}
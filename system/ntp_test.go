@@ -0,0 +1,117 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func TestNTPFile(t *testing.T) {
+	for _, tt := range []struct {
+		config config.NTP
+		file   *File
+		err    error
+	}{
+		{
+			config: config.NTP{},
+		},
+		{
+			config: config.NTP{Client: "chrony"},
+		},
+		{
+			config: config.NTP{Client: "chrony", FallbackServers: []string{"ntp1"}},
+		},
+		{
+			config: config.NTP{Enabled: "false", Servers: []string{"ntp1"}},
+		},
+		{
+			config: config.NTP{Client: "ntpd", Servers: []string{"ntp1"}},
+			err:    &config.ErrorValid{Value: "ntpd", Field: "Client", Valid: "^(systemd-timesyncd|chrony)$"},
+		},
+		{
+			config: config.NTP{Servers: []string{"ntp1", "ntp2"}, Pools: []string{"pool.example.com"}, FallbackServers: []string{"ntp3"}},
+			file: &File{config.File{
+				Path:               "etc/systemd/timesyncd.conf.d/50-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "[Time]\nNTP=ntp1 ntp2 pool.example.com\nFallbackNTP=ntp3\n",
+			}},
+		},
+		{
+			config: config.NTP{Client: "chrony", Servers: []string{"ntp1"}, Pools: []string{"pool.example.com"}},
+			file: &File{config.File{
+				Path:               "etc/chrony/chrony.conf",
+				RawFilePermissions: "0644",
+				Content:            "server ntp1 iburst\npool pool.example.com iburst\ndriftfile /var/lib/chrony/drift\nmakestep 1.0 3\nrtcsync\n",
+			}},
+		},
+	} {
+		file, err := NTP{tt.config}.File()
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (%+v): want %v, got %v", tt.config, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.file, file) {
+			t.Errorf("bad file (%+v): want %#v, got %#v", tt.config, tt.file, file)
+		}
+	}
+}
+
+func TestNTPUnits(t *testing.T) {
+	for _, tt := range []struct {
+		config config.NTP
+		units  []Unit
+	}{
+		{
+			config: config.NTP{},
+		},
+		{
+			config: config.NTP{Servers: []string{"ntp1"}},
+			units: []Unit{{config.Unit{
+				Name:    "systemd-timesyncd.service",
+				Command: "restart",
+				Runtime: true,
+			}}},
+		},
+		{
+			config: config.NTP{Enabled: "false"},
+			units: []Unit{{config.Unit{
+				Name:    "systemd-timesyncd.service",
+				Command: "stop",
+				Mask:    true,
+				Runtime: true,
+			}}},
+		},
+		{
+			config: config.NTP{Client: "chrony"},
+			units: []Unit{{config.Unit{
+				Name:    "systemd-timesyncd.service",
+				Command: "stop",
+				Mask:    true,
+				Runtime: true,
+			}}, {config.Unit{
+				Name:    "chronyd.service",
+				Command: "restart",
+				Runtime: true,
+			}}},
+		},
+	} {
+		units := NTP{tt.config}.Units()
+		if !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (%+v): want %#v, got %#v", tt.config, tt.units, units)
+		}
+	}
+}
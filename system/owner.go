@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The users and groups of the system are looked up in /etc, then in the
+// baselayout, which holds the system users of Flatcar (through nss-altfiles).
+var (
+	passwdFiles = []string{"etc/passwd", "usr/share/baselayout/passwd"}
+	groupFiles  = []string{"etc/group", "usr/share/baselayout/group"}
+)
+
+// LookupOwner resolves the owner, given in one of the forms accepted by
+// chown ("user", "user:group", "user:", ":group", with names or numeric IDs),
+// against the users and groups of the system under root. It returns -1 for
+// the user or group which is left unchanged. As with chown, "user:" stands
+// for the user and its login group.
+func LookupOwner(owner, root string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	user, group, hasGroup := strings.Cut(owner, ":")
+
+	if user != "" {
+		entry, err := lookupEntry(user, root, passwdFiles)
+		if err != nil {
+			return -1, -1, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		if uid, err = entry.id(); err != nil {
+			return -1, -1, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		if hasGroup && group == "" {
+			if len(entry) < 4 {
+				return -1, -1, fmt.Errorf("invalid owner %q: no login group for user %q", owner, user)
+			}
+			if gid, err = strconv.Atoi(entry[3]); err != nil {
+				return -1, -1, fmt.Errorf("invalid owner %q: invalid login group for user %q", owner, user)
+			}
+		}
+	}
+
+	if group != "" {
+		entry, err := lookupEntry(group, root, groupFiles)
+		if err != nil {
+			return -1, -1, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		if gid, err = entry.id(); err != nil {
+			return -1, -1, fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// dbEntry is a line of passwd or group, split into its fields.
+type dbEntry []string
+
+// id returns the ID of the entry, its third field.
+func (e dbEntry) id() (int, error) {
+	if len(e) < 3 {
+		return 0, fmt.Errorf("malformed entry for %q", e[0])
+	}
+	id, err := strconv.Atoi(e[2])
+	if err != nil {
+		return 0, fmt.Errorf("malformed ID for %q", e[0])
+	}
+	return id, nil
+}
+
+// lookupEntry finds the entry of the given name or, if it's numeric, ID in
+// the first of the files which has it. Numeric IDs without an entry are
+// returned as is, as chown would use them.
+func lookupEntry(name, root string, files []string) (dbEntry, error) {
+	_, numErr := strconv.Atoi(name)
+	for _, file := range files {
+		f, err := os.Open(path.Join(root, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entry := dbEntry(strings.Split(line, ":"))
+			if entry[0] == name || (numErr == nil && len(entry) > 2 && entry[2] == name) {
+				f.Close()
+				return entry, nil
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if numErr == nil {
+		return dbEntry{name, "", name}, nil
+	}
+	return nil, fmt.Errorf("unknown user or group %q", name)
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func writeOwnerFiles(t *testing.T, root string) {
+	for name, contents := range map[string]string{
+		"etc/passwd":                  "root:x:0:0:root:/root:/bin/bash\ncore:x:500:500:Core:/home/core:/bin/bash\n",
+		"etc/group":                   "root:x:0:\ncore:x:500:\n# comment\ndocker:x:233:core\n",
+		"usr/share/baselayout/passwd": "etcd:x:232:232:etcd user:/dev/null:/sbin/nologin\n",
+		"usr/share/baselayout/group":  "etcd:x:232:\n",
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(root, name)), 0755); err != nil {
+			t.Fatalf("Unable to create directory: %v", err)
+		}
+		if err := os.WriteFile(path.Join(root, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	root := t.TempDir()
+	writeOwnerFiles(t, root)
+
+	tests := []struct {
+		owner string
+
+		uid int
+		gid int
+		err bool
+	}{
+		{owner: "", uid: -1, gid: -1},
+		{owner: "core", uid: 500, gid: -1},
+		{owner: "core:docker", uid: 500, gid: 233},
+		{owner: "core:", uid: 500, gid: 500},
+		{owner: ":docker", uid: -1, gid: 233},
+		{owner: "etcd:etcd", uid: 232, gid: 232},
+		{owner: "1000:1000", uid: 1000, gid: 1000},
+		{owner: "500", uid: 500, gid: -1},
+		{owner: "nobody", err: true},
+		{owner: "core:nogroup", err: true},
+		{owner: "1000:", err: true},
+	}
+
+	for _, tt := range tests {
+		uid, gid, err := LookupOwner(tt.owner, root)
+		if tt.err != (err != nil) {
+			t.Errorf("bad error (%q): want error %t, got %v", tt.owner, tt.err, err)
+			continue
+		}
+		if !tt.err && (uid != tt.uid || gid != tt.gid) {
+			t.Errorf("bad owner (%q): want %d:%d, got %d:%d", tt.owner, tt.uid, tt.gid, uid, gid)
+		}
+	}
+}
+
+func TestWriteFileOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	root := t.TempDir()
+	writeOwnerFiles(t, root)
+
+	wf := File{config.File{Path: "foo", Owner: "core:docker"}}
+	fullPath, err := WriteFile(&wf, root)
+	if err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+	var st os.FileInfo
+	if st, err = os.Stat(fullPath); err != nil {
+		t.Fatalf("Unable to stat file: %v", err)
+	}
+	if uid, gid := fileOwner(st); uid != 500 || gid != 233 {
+		t.Errorf("File has incorrect owner: %d:%d", uid, gid)
+	}
+
+	wf = File{config.File{Path: "bar", Owner: "nobody"}}
+	if _, err := WriteFile(&wf, root); err == nil {
+		t.Errorf("WriteFile succeeded with an unknown owner")
+	}
+	if _, err := os.Stat(path.Join(root, "bar")); !os.IsNotExist(err) {
+		t.Errorf("File with an unknown owner was written: %v", err)
+	}
+}
+
+func fileOwner(fi os.FileInfo) (int, int) {
+	st := fi.Sys().(*syscall.Stat_t)
+	return int(st.Uid), int(st.Gid)
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const resolvedUnit = "systemd-resolved.service"
+
+// Resolved is a top-level structure which embeds its underlying
+// configuration, config.Resolved, and provides the system-specific File()
+// and Units().
+type Resolved struct {
+	config.Resolved
+}
+
+// File generates a systemd-resolved drop-in with the configured settings.
+func (rc Resolved) File() (*File, error) {
+	if config.IsZero(rc.Resolved) {
+		return nil, nil
+	}
+	if err := config.AssertStructValid(rc.Resolved); err != nil {
+		return nil, err
+	}
+	for _, s := range append(append([]string{}, rc.DNS...), rc.FallbackDNS...) {
+		if err := CheckDNSServer(s); err != nil {
+			return nil, err
+		}
+	}
+
+	out := "[Resolve]\n"
+	for _, o := range []struct {
+		key    string
+		values []string
+	}{
+		{"DNS", rc.DNS},
+		{"FallbackDNS", rc.FallbackDNS},
+		{"Domains", rc.Domains},
+		{"DNSSEC", []string{rc.DNSSEC}},
+		{"DNSOverTLS", []string{rc.DNSOverTLS}},
+	} {
+		if value := strings.TrimSpace(strings.Join(o.values, " ")); value != "" {
+			out += fmt.Sprintf("%s=%s\n", o.key, value)
+		}
+	}
+
+	return &File{config.File{
+		Path:               path.Join("etc", "systemd", "resolved.conf.d", "50-cloudinit.conf"),
+		RawFilePermissions: "0644",
+		Content:            out,
+	}}, nil
+}
+
+// Units generates a unit restarting systemd-resolved, if any setting was
+// configured, for it to pick up the drop-in.
+func (rc Resolved) Units() []Unit {
+	if config.IsZero(rc.Resolved) {
+		return nil
+	}
+	return []Unit{{config.Unit{
+		Name:    resolvedUnit,
+		Command: "restart",
+	}}}
+}
+
+// CheckDNSServer returns an error if the DNS server, given in one of the
+// forms of resolved.conf ("address", "address:port", "[address]:port", each
+// optionally followed by "%interface" and "#server name"), doesn't have a
+// valid IP address.
+func CheckDNSServer(server string) error {
+	host, _, _ := strings.Cut(server, "#")
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return fmt.Errorf("invalid DNS server %q", server)
+		}
+		host = host[1:end]
+	} else if strings.Count(host, ":") == 1 {
+		host, _, _ = strings.Cut(host, ":")
+	}
+	host, _, _ = strings.Cut(host, "%")
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid DNS server %q", server)
+	}
+	return nil
+}
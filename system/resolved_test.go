@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func TestResolvedFile(t *testing.T) {
+	for _, tt := range []struct {
+		config config.Resolved
+		file   *File
+		err    bool
+	}{
+		{
+			config: config.Resolved{},
+		},
+		{
+			config: config.Resolved{
+				DNS:         []string{"192.0.2.1", "[2001:db8::1]:853#dns.example.com"},
+				FallbackDNS: []string{"192.0.2.2:53"},
+				Domains:     []string{"example.com", "~."},
+				DNSSEC:      "allow-downgrade",
+				DNSOverTLS:  "opportunistic",
+			},
+			file: &File{config.File{
+				Path:               "etc/systemd/resolved.conf.d/50-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "[Resolve]\nDNS=192.0.2.1 [2001:db8::1]:853#dns.example.com\nFallbackDNS=192.0.2.2:53\nDomains=example.com ~.\nDNSSEC=allow-downgrade\nDNSOverTLS=opportunistic\n",
+			}},
+		},
+		{
+			config: config.Resolved{DNSSEC: "false"},
+			file: &File{config.File{
+				Path:               "etc/systemd/resolved.conf.d/50-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "[Resolve]\nDNSSEC=false\n",
+			}},
+		},
+		{
+			config: config.Resolved{DNSSEC: "maybe"},
+			err:    true,
+		},
+		{
+			config: config.Resolved{DNS: []string{"dns.example.com"}},
+			err:    true,
+		},
+	} {
+		file, err := Resolved{tt.config}.File()
+		if tt.err != (err != nil) {
+			t.Errorf("bad error (%+v): want error %t, got %v", tt.config, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.file, file) {
+			t.Errorf("bad file (%+v): want %#v, got %#v", tt.config, tt.file, file)
+		}
+	}
+}
+
+func TestResolvedUnits(t *testing.T) {
+	if units := (Resolved{}).Units(); units != nil {
+		t.Errorf("bad units: want nil, got %#v", units)
+	}
+	want := []Unit{{config.Unit{Name: "systemd-resolved.service", Command: "restart"}}}
+	if units := (Resolved{config.Resolved{DNS: []string{"192.0.2.1"}}}).Units(); !reflect.DeepEqual(want, units) {
+		t.Errorf("bad units: want %#v, got %#v", want, units)
+	}
+}
+
+func TestCheckDNSServer(t *testing.T) {
+	for _, tt := range []struct {
+		server string
+		err    bool
+	}{
+		{server: "192.0.2.1"},
+		{server: "192.0.2.1:53"},
+		{server: "192.0.2.1%eth0#dns.example.com"},
+		{server: "2001:db8::1"},
+		{server: "[2001:db8::1]:853"},
+		{server: "[2001:db8::1%eth0]#dns.example.com"},
+		{server: "", err: true},
+		{server: "dns.example.com", err: true},
+		{server: "192.0.2.256", err: true},
+		{server: "[2001:db8::1", err: true},
+	} {
+		if err := CheckDNSServer(tt.server); tt.err != (err != nil) {
+			t.Errorf("bad error (%q): want error %t, got %v", tt.server, tt.err, err)
+		}
+	}
+}
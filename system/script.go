@@ -0,0 +1,50 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// RunScript runs the script synchronously with the given variables added to
+// the environment and logs its output. Scripts without a "#!" line are run
+// with bash, like those run by ExecuteScript.
+func RunScript(scriptPath string, env []string) error {
+	contents, err := ioutil.ReadFile(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(scriptPath)
+	if !bytes.HasPrefix(contents, []byte("#!")) {
+		cmd = exec.Command("/bin/bash", scriptPath)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	log.Printf("Running script %q", scriptPath)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Printf("Output of script %q:\n%s", scriptPath, output)
+	}
+	if err != nil {
+		return fmt.Errorf("script %q failed: %w", scriptPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+const sysctlUnit = "systemd-sysctl.service"
+
+// Sysctl is a top-level structure which embeds its underlying configuration,
+// config.Sysctl, and provides the system-specific File() and Units().
+type Sysctl struct {
+	config.Sysctl
+}
+
+// File generates an `/etc/sysctl.d/90-cloudinit.conf` file with the kernel
+// parameters.
+func (sc Sysctl) File() (*File, error) {
+	params := sc.Params()
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	var out string
+	for _, p := range params {
+		out += fmt.Sprintf("%s = %s\n", p[0], p[1])
+	}
+	return &File{config.File{
+		Path:               path.Join("etc", "sysctl.d", "90-cloudinit.conf"),
+		RawFilePermissions: "0644",
+		Content:            out,
+	}}, nil
+}
+
+// Units generates a unit restarting systemd-sysctl, for the parameters to be
+// applied immediately.
+func (sc Sysctl) Units() []Unit {
+	if len(sc.Params()) == 0 {
+		return nil
+	}
+	return []Unit{{config.Unit{
+		Name:    sysctlUnit,
+		Command: "restart",
+	}}}
+}
+
+// SysctlPath returns the path of the kernel parameter relative to
+// /proc/sys, as sysctl.d(5) reads it: if its first separator is a dot, dots
+// and slashes are swapped.
+func SysctlPath(key string) string {
+	key = strings.TrimPrefix(key, "-")
+	if i := strings.IndexAny(key, "./"); i >= 0 && key[i] == '.' {
+		key = strings.Map(func(r rune) rune {
+			switch r {
+			case '.':
+				return '/'
+			case '/':
+				return '.'
+			}
+			return r
+		}, key)
+	}
+	return key
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flatcar/coreos-cloudinit/config"
+)
+
+func TestSysctl(t *testing.T) {
+	for _, tt := range []struct {
+		config config.Sysctl
+		file   *File
+		units  []Unit
+	}{
+		{
+			config: config.Sysctl{},
+		},
+		{
+			config: config.Sysctl{"invalid"},
+		},
+		{
+			config: config.Sysctl{"net.ipv4.ip_forward=1", "vm.max_map_count = 262144", "-kernel.unknown=1"},
+			file: &File{config.File{
+				Path:               "etc/sysctl.d/90-cloudinit.conf",
+				RawFilePermissions: "0644",
+				Content:            "net.ipv4.ip_forward = 1\nvm.max_map_count = 262144\n-kernel.unknown = 1\n",
+			}},
+			units: []Unit{{config.Unit{Name: "systemd-sysctl.service", Command: "restart"}}},
+		},
+	} {
+		sc := Sysctl{tt.config}
+		file, err := sc.File()
+		if err != nil {
+			t.Errorf("bad error (%q): want nil, got %v", tt.config, err)
+		}
+		if !reflect.DeepEqual(tt.file, file) {
+			t.Errorf("bad file (%q): want %#v, got %#v", tt.config, tt.file, file)
+		}
+		if units := sc.Units(); !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (%q): want %#v, got %#v", tt.config, tt.units, units)
+		}
+	}
+}
+
+func TestSysctlPath(t *testing.T) {
+	for _, tt := range []struct {
+		key  string
+		path string
+	}{
+		{"kernel.domainname", "kernel/domainname"},
+		{"kernel/domainname", "kernel/domainname"},
+		{"-kernel.domainname", "kernel/domainname"},
+		{"net.ipv4.conf.enp3s0/200.forwarding", "net/ipv4/conf/enp3s0.200/forwarding"},
+		{"net/ipv4/conf/enp3s0.200/forwarding", "net/ipv4/conf/enp3s0.200/forwarding"},
+	} {
+		if p := SysctlPath(tt.key); p != tt.path {
+			t.Errorf("bad path (%q): want %q, got %q", tt.key, tt.path, p)
+		}
+	}
+}
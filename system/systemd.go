@@ -15,15 +15,20 @@
 package system
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-systemd/dbus"
+	godbus "github.com/guelfey/go.dbus"
 
 	"github.com/flatcar/coreos-cloudinit/config"
 )
@@ -166,14 +171,24 @@ func nullOrEmpty(path string) (bool, error) {
 	return false, nil
 }
 
-func ExecuteScript(scriptPath string) (string, error) {
-	props := []dbus.Property{
-		dbus.PropDescription("Unit generated and executed by coreos-cloudinit on behalf of user"),
-		dbus.PropExecStart([]string{"/bin/bash", scriptPath}, false),
+// ExecuteScript starts the script in a transient unit configured by opts
+// and returns the name of the unit without waiting for the script to exit.
+// If onStop is not empty, the unit runs it once the script has exited, with
+// full privileges, as its ExecStopPost= command.
+func ExecuteScript(scriptPath string, opts config.ScriptOptions, onStop []string) (string, error) {
+	command, err := scriptCommand(scriptPath, opts.Interpreter)
+	if err != nil {
+		return "", err
+	}
+	props, _, err := unitProperties(command, opts, false)
+	if err != nil {
+		return "", err
+	}
+	if len(onStop) > 0 {
+		props = append(props, execStopPostProperty(onStop))
 	}
 
-	base := path.Base(scriptPath)
-	name := fmt.Sprintf("coreos-cloudinit-%s.service", base)
+	name := ScriptUnitName(scriptPath)
 
 	log.Printf("Creating transient systemd unit '%s'", name)
 
@@ -186,8 +201,223 @@ func ExecuteScript(scriptPath string) (string, error) {
 	return name, err
 }
 
-func SetHostname(hostname string) error {
-	return exec.Command("hostnamectl", "set-hostname", hostname).Run()
+// ScriptUnitName returns the name of the transient unit running the script.
+func ScriptUnitName(scriptPath string) string {
+	return fmt.Sprintf("coreos-cloudinit-%s.service", path.Base(scriptPath))
+}
+
+// ExecuteScriptAndWait runs the script in a transient oneshot unit, like
+// ExecuteScript, but waits for it to exit and returns its exit status, or -1
+// if it is unknown. If opts has a timeout, the script is killed once it has
+// run for that long. An error is returned unless the script exited
+// successfully.
+func ExecuteScriptAndWait(scriptPath string, opts config.ScriptOptions) (string, int32, error) {
+	command, err := scriptCommand(scriptPath, opts.Interpreter)
+	if err != nil {
+		return "", -1, err
+	}
+
+	return executeAndWait(ScriptUnitName(scriptPath), command, opts)
+}
+
+// ExecuteCommandAndWait runs the command in a transient oneshot unit, like
+// ExecuteScriptAndWait. The name of the unit starts with
+// "coreos-cloudinit-<prefix>-". The interpreter of opts is ignored.
+func ExecuteCommandAndWait(prefix string, command []string, opts config.ScriptOptions) (string, int32, error) {
+	name := fmt.Sprintf("coreos-cloudinit-%s-%d.service", prefix, time.Now().UnixNano())
+	return executeAndWait(name, command, opts)
+}
+
+func executeAndWait(name string, command []string, opts config.ScriptOptions) (string, int32, error) {
+	props, timeout, err := unitProperties(command, opts, true)
+	if err != nil {
+		return "", -1, err
+	}
+
+	log.Printf("Creating transient systemd unit '%s' and waiting for it to exit", name)
+
+	conn, err := dbus.New()
+	if err != nil {
+		return "", -1, err
+	}
+
+	type jobResult struct {
+		result string
+		err    error
+	}
+	done := make(chan jobResult, 1)
+	go func() {
+		result, err := conn.StartTransientUnit(name, "replace", props...)
+		done <- jobResult{result, err}
+	}()
+
+	// systemd enforces the timeout itself, this only guards against the job
+	// never completing.
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout + time.Minute)
+	}
+
+	var job jobResult
+	select {
+	case job = <-done:
+	case <-expired:
+		conn.KillUnit(name, int32(syscall.SIGKILL))
+		return name, -1, fmt.Errorf("unit %s did not exit within %s", name, timeout)
+	}
+	if job.err != nil {
+		return name, -1, job.err
+	}
+	if job.result == "done" {
+		log.Printf("Unit '%s' exited successfully", name)
+		return name, 0, nil
+	}
+
+	var state string
+	if p, err := conn.GetUnitProperty(name, "ActiveState"); err == nil {
+		state, _ = p.Value.Value().(string)
+	}
+	status := int32(-1)
+	if p, err := conn.GetUnitTypeProperty(name, "Service", "ExecMainStatus"); err == nil {
+		status, _ = p.Value.Value().(int32)
+	}
+	return name, status, scriptError(name, job.result, state, status)
+}
+
+// unitProperties returns the properties of the transient unit running the
+// command with the given options, and its timeout. Commands are killed once
+// they have run for longer than the timeout: oneshot units through their
+// start timeout, others through their maximum runtime.
+func unitProperties(command []string, opts config.ScriptOptions, oneshot bool) ([]dbus.Property, time.Duration, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, 0, err
+	}
+	var timeout time.Duration
+	if opts.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(opts.Timeout); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(command) == 0 {
+		return nil, 0, fmt.Errorf("empty command")
+	}
+
+	props := []dbus.Property{
+		dbus.PropDescription("Unit generated and executed by coreos-cloudinit on behalf of user"),
+		dbus.PropExecStart(command, false),
+	}
+	if oneshot {
+		props = append(props, dbus.Property{Name: "Type", Value: godbus.MakeVariant("oneshot")})
+	}
+	if timeout > 0 {
+		name := "RuntimeMaxUSec"
+		if oneshot {
+			name = "TimeoutStartUSec"
+		}
+		props = append(props, dbus.Property{Name: name, Value: godbus.MakeVariant(uint64(timeout / time.Microsecond))})
+	}
+	if len(opts.Environment) > 0 {
+		props = append(props, dbus.Property{Name: "Environment", Value: godbus.MakeVariant(opts.Environment)})
+	}
+	for _, p := range []struct{ name, value string }{
+		{"User", opts.User},
+		{"WorkingDirectory", opts.WorkingDirectory},
+		{"ProtectSystem", opts.ProtectSystem},
+		{"ProtectHome", opts.ProtectHome},
+	} {
+		if p.value != "" {
+			props = append(props, dbus.Property{Name: p.name, Value: godbus.MakeVariant(p.value)})
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value bool
+	}{
+		{"PrivateTmp", opts.PrivateTmp},
+		{"PrivateNetwork", opts.PrivateNetwork},
+		{"PrivateDevices", opts.PrivateDevices},
+		{"NoNewPrivileges", opts.NoNewPrivileges},
+	} {
+		if p.value {
+			props = append(props, dbus.Property{Name: p.name, Value: godbus.MakeVariant(true)})
+		}
+	}
+	return props, timeout, nil
+}
+
+// execCommandEx is an ExecStartEx= style command: its path, its arguments
+// and the flags of the command line prefixes, such as "privileged" for "+".
+type execCommandEx struct {
+	Path  string
+	Args  []string
+	Flags []string
+}
+
+// execStopPostProperty runs the command with full privileges, ignoring User=
+// and the sandboxing options, and ignoring its failure, once the unit has
+// stopped.
+func execStopPostProperty(command []string) dbus.Property {
+	return dbus.Property{
+		Name:  "ExecStopPostEx",
+		Value: godbus.MakeVariant([]execCommandEx{{command[0], command, []string{"privileged", "ignore-failure"}}}),
+	}
+}
+
+// scriptCommand returns the command running the script: the interpreter if
+// one is given, otherwise the command of its "#!" line or, if it has none,
+// bash. Like the kernel does, everything following the command on the "#!"
+// line is passed as a single argument.
+func scriptCommand(scriptPath, interpreter string) ([]string, error) {
+	if interpreter != "" {
+		return append(strings.Fields(interpreter), scriptPath), nil
+	}
+
+	f, err := os.Open(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	shebang := strings.TrimSpace(strings.TrimPrefix(line, "#!"))
+	if !strings.HasPrefix(line, "#!") || shebang == "" {
+		return []string{"/bin/bash", scriptPath}, nil
+	}
+	if i := strings.IndexAny(shebang, " \t"); i >= 0 {
+		return []string{shebang[:i], strings.TrimSpace(shebang[i:]), scriptPath}, nil
+	}
+	return []string{shebang, scriptPath}, nil
+}
+
+// UnitOutput returns at most the given number of the last lines logged by
+// the unit to the journal.
+func UnitOutput(name string, lines int) ([]byte, error) {
+	return exec.Command("journalctl", "--unit", name, "--output", "cat", "--no-pager", "--lines", fmt.Sprint(lines)).Output()
+}
+
+// scriptError describes the failure of the unit running a script given its
+// job result, ActiveState and ExecMainStatus. A negative status is unknown.
+func scriptError(name, result, state string, status int32) error {
+	if status < 0 {
+		return fmt.Errorf("unit %s %s (state %q)", name, result, state)
+	}
+	return fmt.Errorf("unit %s %s (state %q, exit status %d)", name, result, state, status)
+}
+
+// SetHostname sets the static and transient hostnames and, if it's not
+// empty, the pretty hostname, e.g. to the FQDN when the hostname is short.
+func SetHostname(hostname, pretty string) error {
+	if err := exec.Command("hostnamectl", "set-hostname", "--static", "--transient", hostname).Run(); err != nil {
+		return err
+	}
+	if pretty == "" {
+		return nil
+	}
+	return exec.Command("hostnamectl", "set-hostname", "--pretty", pretty).Run()
 }
 
 func Hostname() (string, error) {
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 
 	"github.com/flatcar/coreos-cloudinit/config"
@@ -278,3 +279,116 @@ func TestNullOrEmpty(t *testing.T) {
 	}
 
 }
+
+func TestScriptError(t *testing.T) {
+	for _, tt := range []struct {
+		result string
+		state  string
+		status int32
+		err    string
+	}{
+		{"failed", "failed", 2, `unit a.service failed (state "failed", exit status 2)`},
+		{"failed", "", -1, `unit a.service failed (state "")`},
+	} {
+		if err := scriptError("a.service", tt.result, tt.state, tt.status); err.Error() != tt.err {
+			t.Errorf("bad error (%s, %s, %d): want %q, got %q", tt.result, tt.state, tt.status, tt.err, err)
+		}
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		contents    string
+		interpreter string
+		command     []string
+	}{
+		{"#!/bin/sh\necho", "", []string{"/bin/sh"}},
+		{"#! /usr/bin/env  python3 -u\nprint()", "", []string{"/usr/bin/env", "python3 -u"}},
+		{"echo", "", []string{"/bin/bash"}},
+		{"#!", "", []string{"/bin/bash"}},
+		{"#!/bin/sh\necho", "/usr/bin/python3 -u", []string{"/usr/bin/python3", "-u"}},
+	} {
+		script := path.Join(dir, "script")
+		if err := ioutil.WriteFile(script, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		want := append(tt.command, script)
+		command, err := scriptCommand(script, tt.interpreter)
+		if err != nil {
+			t.Errorf("bad error (%q, %q): want nil, got %v", tt.contents, tt.interpreter, err)
+		} else if fmt.Sprint(command) != fmt.Sprint(want) {
+			t.Errorf("bad command (%q, %q): want %q, got %q", tt.contents, tt.interpreter, want, command)
+		}
+	}
+}
+
+func TestUnitProperties(t *testing.T) {
+	for _, tt := range []struct {
+		opts    config.ScriptOptions
+		oneshot bool
+		props   map[string]string
+		err     bool
+	}{
+		{
+			opts:  config.ScriptOptions{},
+			props: map[string]string{},
+		},
+		{
+			opts:    config.ScriptOptions{Timeout: "5m", User: "core", PrivateTmp: true},
+			oneshot: true,
+			props:   map[string]string{"Type": `"oneshot"`, "TimeoutStartUSec": "@t 300000000", "User": `"core"`, "PrivateTmp": "true"},
+		},
+		{
+			opts:  config.ScriptOptions{Timeout: "90s", Environment: []string{"A=1"}, ProtectSystem: "strict"},
+			props: map[string]string{"RuntimeMaxUSec": "@t 90000000", "Environment": `["A=1"]`, "ProtectSystem": `"strict"`},
+		},
+		{
+			opts: config.ScriptOptions{ProtectSystem: "yes"},
+			err:  true,
+		},
+		{
+			opts: config.ScriptOptions{Environment: []string{"A"}},
+			err:  true,
+		},
+	} {
+		props, _, err := unitProperties([]string{"/bin/true"}, tt.opts, tt.oneshot)
+		if tt.err {
+			if err == nil {
+				t.Errorf("bad error (%+v): want an error, got nil", tt.opts)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("bad error (%+v): want nil, got %v", tt.opts, err)
+			continue
+		}
+
+		got := map[string]string{}
+		for _, p := range props {
+			if p.Name != "Description" && p.Name != "ExecStart" {
+				got[p.Name] = p.Value.String()
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.props) {
+			t.Errorf("bad properties (%+v): want %v, got %v", tt.opts, tt.props, got)
+		}
+	}
+}
+
+func TestExecStopPostProperty(t *testing.T) {
+	p := execStopPostProperty([]string{"/usr/bin/coreos-cloudinit", "finish-script-run", "/run"})
+	if p.Name != "ExecStopPostEx" {
+		t.Errorf("bad name: want %q, got %q", "ExecStopPostEx", p.Name)
+	}
+	if sig := p.Value.Signature().String(); sig != "a(sasas)" {
+		t.Errorf("bad signature: want %q, got %q", "a(sasas)", sig)
+	}
+	want := []execCommandEx{{
+		"/usr/bin/coreos-cloudinit",
+		[]string{"/usr/bin/coreos-cloudinit", "finish-script-run", "/run"},
+		[]string{"privileged", "ignore-failure"},
+	}}
+	if v := p.Value.Value(); !reflect.DeepEqual(want, v) {
+		t.Errorf("bad value: want %#v, got %#v", want, v)
+	}
+}